@@ -0,0 +1,15 @@
+package controller
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+)
+
+const LoggersControllerAcornName = "loggersctl"
+
+// LoggersController provides a management endpoint to view and change the log level at runtime
+type LoggersController interface {
+	IsLoggersController() bool
+
+	WireUp(ctx context.Context, router chi.Router)
+}
@@ -1,6 +1,10 @@
 package repository
 
-import "context"
+import (
+	"context"
+	"regexp"
+	"time"
+)
 
 const ConfigurationAcornName = "configuration"
 
@@ -17,6 +21,19 @@ type CustomConfiguration interface {
 	// ... in your implementation, you should put accessors here
 }
 
+// ConfigChangeEvent describes a single configuration value that was changed by a reload.
+type ConfigChangeEvent struct {
+	Key      string
+	OldValue string
+	NewValue string
+}
+
+// ConfigChangeSubscriber is called after a successful reload that changed at least one reloadable value.
+//
+// All changes from one reload are delivered in a single call. The configuration accessors already
+// return the new values when the subscriber is called.
+type ConfigChangeSubscriber func(ctx context.Context, events []ConfigChangeEvent)
+
 // Configuration is the central singleton representing the configuration.
 //
 // In normal operation, all values come from environment variables, but for localhost convenience we
@@ -35,15 +52,31 @@ type Configuration interface {
 	// Validate the configuration (logs detailed validation errors, so needs logging set up)
 	Validate(ctx context.Context) error
 
+	// Reload re-reads the configuration from its sources and validates it.
+	//
+	// Only the values of configuration items marked reloadable are changed. Changes to other values are logged
+	// as warnings and otherwise ignored. If validation fails, all values are left as they were.
+	//
+	// Subscribers are notified of the changed values after a successful reload.
+	Reload(ctx context.Context) error
+
+	// Subscribe registers a subscriber that is notified of changed values after each successful Reload.
+	Subscribe(subscriber ConfigChangeSubscriber)
+
+	// StartReloading calls Reload periodically until ctx is cancelled.
+	//
+	// If watchFile is set, a reload is only attempted when the modification time of the local
+	// configuration file has changed since the last check.
+	StartReloading(ctx context.Context, interval time.Duration, watchFile bool)
+
 	// Custom gives you access to your custom configuration value object.
 	//
-	// TODO sorry for forcing you to type cast for now, but interfaces with generics aren't quite there yet.
-	// It's funny, but if you make the return type of this method a generic type argument, there is actually
-	// no way to implement the method (except for one specific type, which is precisely NOT what we need).
+	// Interfaces with generics aren't quite there yet. It's funny, but if you make the return type of this
+	// method a generic type argument, there is actually no way to implement the method (except for one
+	// specific type, which is precisely NOT what we need).
 	//
-	// I have found it convenient to do the type cast in the acorn setup, meaning, if your code needs any
-	// custom configuration values, just obtain this reference at setup time rather than casting every
-	// time you need a configuration value.
+	// So instead of type casting yourself, use config.CustomAs[T](), or construct the configuration
+	// with config.NewTyped[T]() and use its Typed() method.
 	Custom() CustomConfiguration
 
 	// expose no-acorn setup operations
@@ -64,6 +97,22 @@ type Configuration interface {
 
 	PlainLogging() bool
 	LogLevel() string
+	// LogLevels returns the log level overrides for individual logger names (log.logger field)
+	LogLevels() map[string]string
+	// LogSamplingBurst returns 0 if log sampling is switched off
+	LogSamplingBurst() uint
+	LogSamplingPeriodSeconds() uint
+	LogSamplingThereafter() uint
+	// LogRedactFields returns the lowercase names of log fields whose values are masked
+	LogRedactFields() []string
+	// LogRedactPatterns returns the regular expressions whose matches are masked in all log entries
+	LogRedactPatterns() []*regexp.Regexp
+
+	// BasicAuthUsers returns the configured basic auth users, with their password hashes
+	BasicAuthUsers() []BasicAuthUser
+
+	// ApiKeys returns the configured api keys, by their hash
+	ApiKeys() []ApiKey
 
 	VaultServer() string
 	VaultCertificateFile() string
@@ -78,3 +127,28 @@ type Configuration interface {
 
 	CorsAllowOrigin() string
 }
+
+// BasicAuthUser is a set of basic auth credentials, and the claims the authenticated user gets.
+type BasicAuthUser struct {
+	Username string `json:"username"`
+	// PasswordHash is a bcrypt hash, or an argon2id hash in the format $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+	PasswordHash string `json:"passwordHash,omitempty"`
+
+	Name   string   `json:"name,omitempty"`
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// ApiKey is the hash of an api key, and the claims the client using the key gets.
+type ApiKey struct {
+	// KeyHash is the hex encoded sha256 hash of the key. A fast hash is fine because api keys are long random strings.
+	KeyHash string `json:"keyHash"`
+
+	Name   string   `json:"name,omitempty"`
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
@@ -1,9 +1,16 @@
 package repository
 
-import auloggingapi "github.com/StephanHCB/go-autumn-logging/api"
+import (
+	"context"
+	auloggingapi "github.com/StephanHCB/go-autumn-logging/api"
+	"time"
+)
 
 const LoggingAcornName = "logging"
 
+// RootLoggerName is the name under which the global log level is managed, same as in Spring
+const RootLoggerName = "ROOT"
+
 // Logging is the central singleton representing the logging subsystem.
 type Logging interface {
 	IsLogging() bool
@@ -13,4 +20,35 @@ type Logging interface {
 
 	// Logger gives you access to the logging implementation
 	Logger() auloggingapi.LoggingImplementation
+
+	// Levels returns information about the current log levels.
+	//
+	// The global log level is listed under RootLoggerName, together with all logger names
+	// that have a level of their own.
+	Levels() map[string]LogLevelInfo
+
+	// SetLevel changes the log level for a logger name at runtime.
+	//
+	// Use RootLoggerName to change the global log level. Other names refer to the log.logger field.
+	//
+	// If ttl is > 0, the level from the configuration is restored automatically after ttl has passed.
+	// Setting an empty level restores the level from the configuration immediately.
+	//
+	// The change is not logged here, so the caller can log it together with who requested it.
+	SetLevel(ctx context.Context, name string, level string, ttl time.Duration) error
+
+	// Redact masks all occurrences of a sensitive value, such as a secret obtained from Vault, in all future log entries.
+	//
+	// Field names and patterns to mask are configured, see LOG_REDACT_FIELDS and LOG_REDACT_PATTERNS.
+	Redact(value string)
+}
+
+// LogLevelInfo describes the current log level.
+type LogLevelInfo struct {
+	// ConfiguredLevel is the level from the configuration, empty if a logger name has no configured level
+	ConfiguredLevel string
+	// EffectiveLevel is the level currently in effect, which differs from ConfiguredLevel if it was changed at runtime
+	EffectiveLevel string
+	// RevertAt is set if the level was changed at runtime with a ttl
+	RevertAt *time.Time
 }
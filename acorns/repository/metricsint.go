@@ -0,0 +1,22 @@
+package repository
+
+import "context"
+
+const MetricsAcornName = "metrics"
+
+// Metrics is the central singleton for metrics about the application as a whole.
+type Metrics interface {
+	IsMetrics() bool
+
+	// Setup registers the application info, process and Go runtime metrics.
+	//
+	// It also records the startup time so far, so the startup time metric is set even if Started is never called.
+	Setup() error
+
+	// Started records how long it took to start the application.
+	//
+	// Call it once all acorns are set up, e.g. right before your server starts listening.
+	// The startup time is measured from the creation of this component, which the acorn
+	// registry does before any acorn is set up.
+	Started(ctx context.Context)
+}
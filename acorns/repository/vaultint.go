@@ -20,6 +20,9 @@ type Vault interface {
 
 	// ObtainSecrets fetches the regular secrets from vault
 	ObtainSecrets(ctx context.Context) error
+
+	// ObtainSecretsAt fetches all secrets at a vault path, without putting them into the configuration
+	ObtainSecretsAt(ctx context.Context, path string) (map[string]string, error)
 }
 
 type VaultConfiguration interface {
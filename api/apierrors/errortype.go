@@ -0,0 +1,77 @@
+package apierrors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrorTypeNone is the error type of requests that did not pass through HandleError, same as "None" in Spring.
+const ErrorTypeNone = "None"
+
+var errorTypeNames = map[int]string{
+	http.StatusBadRequest:          "BadRequestError",
+	http.StatusUnauthorized:        "UnauthorisedError",
+	http.StatusForbidden:           "ForbiddenError",
+	http.StatusNotFound:            "NotFoundError",
+	http.StatusConflict:            "ConflictError",
+	http.StatusInternalServerError: "InternalServerError",
+	http.StatusBadGateway:          "BadGatewayError",
+	http.StatusGatewayTimeout:      "GatewayTimeoutError",
+}
+
+type errorTypeRecorderKey struct{}
+
+type errorTypeRecorder struct {
+	lock  sync.Mutex
+	value string
+}
+
+// RecordErrorType returns a child context in which HandleError records the type of the error it handled.
+//
+// Used by the request metrics middleware, see RecordedErrorType.
+func RecordErrorType(ctx context.Context) context.Context {
+	return context.WithValue(ctx, errorTypeRecorderKey{}, &errorTypeRecorder{value: ErrorTypeNone})
+}
+
+// RecordedErrorType returns the type of the error handled by HandleError, or ErrorTypeNone.
+func RecordedErrorType(ctx context.Context) string {
+	recorder, ok := ctx.Value(errorTypeRecorderKey{}).(*errorTypeRecorder)
+	if !ok {
+		return ErrorTypeNone
+	}
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	return recorder.value
+}
+
+// ErrorTypeName is the name of an error type for use in metrics.
+//
+// For the errors declared in this package, this is the name used in the NewXyz function, e.g. "NotFoundError".
+func ErrorTypeName(err error) string {
+	if annotatedError, ok := err.(AnnotatedError); ok {
+		if name, ok := errorTypeNames[annotatedError.HttpStatus()]; ok {
+			return name
+		}
+	}
+	return strings.TrimLeft(fmt.Sprintf("%T", err), "*")
+}
+
+func recordErrorType(ctx context.Context, r *http.Request, err error) {
+	recorder, ok := ctx.Value(errorTypeRecorderKey{}).(*errorTypeRecorder)
+	if !ok && r != nil {
+		recorder, ok = r.Context().Value(errorTypeRecorderKey{}).(*errorTypeRecorder)
+	}
+	if !ok {
+		return
+	}
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.value = ErrorTypeName(err)
+}
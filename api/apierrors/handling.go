@@ -19,6 +19,8 @@ import (
 //
 // Pass any number of the IsXyz functions from this package for expectedTypes.
 func HandleError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error, expectedTypes ...func(error) bool) {
+	recordErrorType(ctx, r, err)
+
 	annotatedError, ok := err.(AnnotatedError)
 	if ok {
 		for _, typeCheck := range expectedTypes {
@@ -12,3 +12,19 @@ type HealthComponent struct {
 	Description *string `json:"description,omitempty"`
 	Status      *string `json:"status,omitempty"`
 }
+
+type LoggerLevelDto struct {
+	ConfiguredLevel *string    `json:"configuredLevel,omitempty"`
+	EffectiveLevel  *string    `json:"effectiveLevel,omitempty"`
+	RevertAt        *time.Time `json:"revertAt,omitempty"`
+}
+
+type LoggersDto struct {
+	Levels  []string                  `json:"levels"`
+	Loggers map[string]LoggerLevelDto `json:"loggers"`
+}
+
+type LoggerLevelChangeDto struct {
+	ConfiguredLevel *string `json:"configuredLevel,omitempty"`
+	TtlSeconds      *int    `json:"ttlSeconds,omitempty"`
+}
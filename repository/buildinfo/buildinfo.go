@@ -0,0 +1,49 @@
+// Package buildinfo provides version information about the running service from the Go build info.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version overrides the version taken from the Go build info.
+//
+// You can set it from your code, or with -ldflags "-X github.com/StephanHCB/go-backend-service-common/repository/buildinfo.Version=...".
+var Version = ""
+
+// ServiceVersion is Version if set, otherwise the module version, otherwise the vcs revision.
+//
+// Returns an empty string if nothing is known, e.g. in tests.
+func ServiceVersion() string {
+	if Version != "" {
+		return Version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return Revision()
+}
+
+// Revision is the vcs revision the service was built from, if known.
+func Revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// GoVersion is the version of Go the service was built with.
+func GoVersion() string {
+	return runtime.Version()
+}
@@ -3,6 +3,7 @@ package config
 import (
 	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
 	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"sync"
 )
 
 // ApplicationName is only used to set up minimal logging if the configuration cannot be read.
@@ -21,6 +22,7 @@ const (
 	KeyPlatform             = "PLATFORM"
 	KeyLogstyle             = "LOGSTYLE"
 	KeyLogLevel             = "LOG_LEVEL"
+	KeyLogLevels            = "LOG_LEVELS"
 	KeyVaultServer          = "VAULT_SERVER"
 	KeyVaultCertificateFile = "VAULT_CERTIFICATE_FILE"
 	// KeyVaultSecretPath deprecated please migrate to KeyVaultSecretsConfig
@@ -50,6 +52,40 @@ const (
 	KeyVaultAuthKubernetesTokenPath = "VAULT_AUTH_KUBERNETES_TOKEN_PATH"
 	KeyVaultAuthKubernetesBackend   = "VAULT_AUTH_KUBERNETES_BACKEND"
 	KeyVaultSecretsConfig           = "VAULT_SECRETS_CONFIG"
+
+	KeyLoggersAdminGroup = "LOGGERS_ADMIN_GROUP"
+
+	KeyLogSamplingBurst      = "LOG_SAMPLING_BURST"
+	KeyLogSamplingPeriod     = "LOG_SAMPLING_PERIOD_SECONDS"
+	KeyLogSamplingThereafter = "LOG_SAMPLING_THEREAFTER"
+
+	KeyLogRedactFields   = "LOG_REDACT_FIELDS"
+	KeyLogRedactPatterns = "LOG_REDACT_PATTERNS"
+
+	KeyBasicAuthUsers          = "BASIC_AUTH_USERS"
+	KeyBasicAuthPasswordHashes = "BASIC_AUTH_PASSWORD_HASHES"
+	KeyApiKeys                 = "API_KEYS"
+)
+
+// Reloadable marks a configuration item as one that may change at runtime through Reload(), and returns it.
+//
+// Use it where you declare your own configuration items, e.g. for feature toggles:
+//
+//	config.Reloadable(auconfigapi.ConfigItem{Key: "FEATURE_X", ...})
+//
+// Must be done before calling New(). Changes to any configuration item not marked reloadable are
+// logged as a warning during Reload() and otherwise ignored.
+func Reloadable(item auconfigapi.ConfigItem) auconfigapi.ConfigItem {
+	reloadableLock.Lock()
+	defer reloadableLock.Unlock()
+
+	reloadableItems[item.Key] = true
+	return item
+}
+
+var (
+	reloadableLock  sync.Mutex
+	reloadableItems = make(map[string]bool)
 )
 
 // PredefinedConfigItems is exposed so you can customize it.
@@ -102,12 +138,71 @@ var PredefinedConfigItems = []auconfigapi.ConfigItem{
 		Default:     "ecs",
 		Description: "toggle between json ecs logging and plaintext logging (for local development)",
 		Validate:    auconfigenv.ObtainPatternValidator("^(plain|ecs)$"),
-	}, {
+	}, Reloadable(auconfigapi.ConfigItem{
 		Key:         KeyLogLevel,
 		EnvName:     KeyLogLevel,
 		Default:     "INFO",
 		Description: "minimum level of logged messages",
 		Validate:    auconfigenv.ObtainPatternValidator("^[a-zA-Z]+$"),
+	}), Reloadable(auconfigapi.ConfigItem{
+		Key:         KeyLogLevels,
+		EnvName:     KeyLogLevels,
+		Default:     "",
+		Description: "optional: comma separated list of minimum levels for individual logger names (log.logger field), overriding LOG_LEVEL. Example: 'request.incoming=WARN,vault=DEBUG'",
+		Validate:    auconfigenv.ObtainPatternValidator("^(|[a-zA-Z0-9._-]+=[a-zA-Z]+(,[a-zA-Z0-9._-]+=[a-zA-Z]+)*)$"),
+	}), {
+		Key:         KeyLogSamplingBurst,
+		EnvName:     KeyLogSamplingBurst,
+		Default:     "0",
+		Description: "json logging only: number of log entries with the same level and message written per sampling period before sampling starts. 0 switches off sampling",
+		Validate:    auconfigenv.ObtainUintRangeValidator(0, 1000000),
+	}, {
+		Key:         KeyLogSamplingPeriod,
+		EnvName:     KeyLogSamplingPeriod,
+		Default:     "10",
+		Description: "json logging only: length of the sampling period in seconds. A summary of dropped log entries is written at the end of each period",
+		Validate:    auconfigenv.ObtainUintRangeValidator(1, 3600),
+	}, {
+		Key:         KeyLogSamplingThereafter,
+		EnvName:     KeyLogSamplingThereafter,
+		Default:     "100",
+		Description: "json logging only: once the burst is exhausted, only every n-th log entry with the same level and message is written for the rest of the sampling period. 0 drops all of them",
+		Validate:    auconfigenv.ObtainUintRangeValidator(0, 1000000),
+	}, {
+		Key:         KeyLogRedactFields,
+		EnvName:     KeyLogRedactFields,
+		Default:     "authorization,cookie,set-cookie,password,secret,token,access_token,refresh_token,id_token,client_secret,api_key,x-api-key",
+		Description: "comma separated list of log field names (case insensitive) whose values are masked in all log entries. Set to '-' to mask no fields",
+		Validate:    auconfigenv.ObtainPatternValidator("^(-|[a-zA-Z0-9._-]+(,[a-zA-Z0-9._-]+)*)$"),
+	}, {
+		Key:         KeyLogRedactPatterns,
+		EnvName:     KeyLogRedactPatterns,
+		Default:     `["(?i)bearer\\s+[a-z0-9._~+/=-]+", "eyJ[a-zA-Z0-9_-]+\\.[a-zA-Z0-9_-]+\\.[a-zA-Z0-9_-]*", "[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\\.[a-zA-Z]{2,}"]`,
+		Description: "json array of regular expressions. Matches are masked in log messages and all other string fields of all log entries. The default masks bearer tokens, JWTs and email addresses. Set to '[]' to mask no patterns",
+		Validate:    validateRedactPatterns,
+	}, {
+		Key:     KeyBasicAuthUsers,
+		EnvName: KeyBasicAuthUsers,
+		Default: "[]",
+		Description: "json array of basic auth users, e.g. " +
+			`[{"username": "ci", "passwordHash": "$2a$10$...", "name": "CI", "groups": ["deployers"], "roles": [], "scopes": []}]. ` +
+			"The passwordHash is a bcrypt or argon2id hash. Leave it out to take it from " + KeyBasicAuthPasswordHashes +
+			". Usernames must not contain dots",
+		Validate: validateBasicAuthUsers,
+	}, {
+		Key:     KeyBasicAuthPasswordHashes,
+		EnvName: KeyBasicAuthPasswordHashes,
+		Default: "{}",
+		Description: "json object of bcrypt or argon2id password hashes by basic auth username. " +
+			"Meant to be filled from vault, with configKey " + KeyBasicAuthPasswordHashes + ".<username>",
+		Validate: validateBasicAuthPasswordHashes,
+	}, {
+		Key:     KeyApiKeys,
+		EnvName: KeyApiKeys,
+		Default: "[]",
+		Description: "json array of api keys, given by the hex encoded sha256 hash of the key, e.g. " +
+			`[{"keyHash": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", "name": "billing", "groups": ["billing-clients"]}]`,
+		Validate: validateApiKeys,
 	}, {
 		Key:         KeyVaultServer,
 		EnvName:     KeyVaultServer,
@@ -153,11 +248,11 @@ var PredefinedConfigItems = []auconfigapi.ConfigItem{
 		Default:     "",
 		Description: "role binding to use for vault kubernetes authentication, usually <PLATFORM>_microservice_role_<APPNAME>_<ENVIRONMENT>",
 		Validate:    auconfigenv.ObtainPatternValidator("^(|k8s-[a-z-]+|aks-[a-z-]+)$"),
-	}, {
+	}, Reloadable(auconfigapi.ConfigItem{
 		Key:         KeyCorsAllowOrigin,
 		EnvName:     KeyCorsAllowOrigin,
 		Default:     "",
 		Description: "setting this enables sending headers to reduce CORS protections. Not usually suitable for production. Leave blank to not disable CORS. Note that this needs to be a single http(s) base URL, or else credentials forwarding will be refused by modern browsers. If you don't need credentials, this can be a comma separated list. Typical example value: 'http://localhost:8000/'",
 		Validate:    auconfigenv.ObtainPatternValidator("^(|https?://.*)$"),
-	},
+	}),
 }
@@ -0,0 +1,111 @@
+package configcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/StephanHCB/go-backend-service-common/repository/vault"
+	"io"
+	"os"
+)
+
+const (
+	HelpFlag     = "--help-config"
+	ValidateFlag = "--validate-config"
+)
+
+// Execute handles the configuration command line modes, and exits the process if one of them was requested.
+//
+// Call this from your main function right after constructing the configuration, before anything else is set up:
+//
+//	configcheck.Execute(configuration, os.Args[1:])
+//
+// With --help-config, every registered configuration item is printed with its description and default value.
+//
+// With --validate-config, the configuration is read and validated, including the mapping of vault secrets
+// to configuration keys, and all errors are printed. The exit code is non-zero if there were errors.
+//
+// If neither flag is present, Execute returns without doing anything.
+func Execute(configuration repository.Configuration, args []string) {
+	handled, exitCode := Run(configuration, args, os.Stdout)
+	if handled {
+		os.Exit(exitCode)
+	}
+}
+
+// checkable is what the configuration checks need, it is implemented by the results of config.New() and config.NewTyped()
+type checkable interface {
+	ConfigItems() []auconfigapi.ConfigItem
+	IsReloadable(key string) bool
+	Read() error
+}
+
+// Run does the work for Execute, but returns instead of exiting the process. Exposed for testing.
+func Run(configuration repository.Configuration, args []string, out io.Writer) (handled bool, exitCode int) {
+	c, ok := configuration.(checkable)
+	if !ok {
+		_, _ = fmt.Fprintln(out, "received invalid component as configuration. You can only run configuration checks on a configuration instance.")
+		return true, 2
+	}
+
+	for _, arg := range args {
+		switch arg {
+		case HelpFlag:
+			printHelp(c, out)
+			return true, 0
+		case ValidateFlag:
+			return true, validate(c, out)
+		}
+	}
+	return false, 0
+}
+
+func printHelp(c checkable, out io.Writer) {
+	for _, it := range c.ConfigItems() {
+		_, _ = fmt.Fprintln(out, it.EnvName)
+		_, _ = fmt.Fprintf(out, "    %s\n", it.Description)
+		_, _ = fmt.Fprintf(out, "    default: '%v'\n", it.Default)
+		if c.IsReloadable(it.Key) {
+			_, _ = fmt.Fprintln(out, "    can be changed at runtime")
+		}
+	}
+}
+
+func validate(c checkable, out io.Writer) int {
+	if err := c.Read(); err != nil {
+		_, _ = fmt.Fprintf(out, "failed to read configuration: %s\n", err.Error())
+		return 1
+	}
+
+	errorList := make([]error, 0)
+	knownKeys := make(map[string]bool)
+	for _, it := range c.ConfigItems() {
+		knownKeys[it.Key] = true
+		if it.Validate != nil {
+			if err := it.Validate(it.Key); err != nil {
+				errorList = append(errorList, fmt.Errorf("configuration field %s: %s", it.EnvName, err.Error()))
+			}
+		}
+	}
+
+	if knownKeys[config.KeyVaultSecretsConfig] {
+		secretsConfig := repository.VaultSecretsConfig{}
+		if err := json.Unmarshal([]byte(auconfigenv.Get(config.KeyVaultSecretsConfig)), &secretsConfig); err == nil {
+			errorList = append(errorList, vault.CheckSecretsConfig(secretsConfig, knownKeys)...)
+		}
+	}
+
+	for _, err := range errorList {
+		_, _ = fmt.Fprintln(out, err.Error())
+	}
+
+	if len(errorList) > 0 {
+		_, _ = fmt.Fprintf(out, "configuration is invalid. There were %d error(s)\n", len(errorList))
+		return 1
+	}
+	_, _ = fmt.Fprintln(out, "configuration is valid")
+	return 0
+}
@@ -1,75 +1,191 @@
 package config
 
-import "github.com/StephanHCB/go-backend-service-common/acorns/repository"
+import (
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"regexp"
+)
 
+// Custom returns the custom configuration. Reload() replaces it with a new object, rather than changing it.
 func (c *ConfigImpl) Custom() repository.CustomConfiguration {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.CustomConfiguration
 }
 
 func (c *ConfigImpl) ApplicationName() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VApplicationName
 }
 
 func (c *ConfigImpl) ServerAddress() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VServerAddress
 }
 
 func (c *ConfigImpl) ServerPort() uint16 {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VServerPortValue
 }
 
 func (c *ConfigImpl) MetricsPort() uint16 {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VMetricsPortValue
 }
 
 func (c *ConfigImpl) Environment() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VEnvironment
 }
 
 func (c *ConfigImpl) Platform() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VPlatform
 }
 
 func (c *ConfigImpl) PlainLogging() bool {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VLogstyle == "plain"
 }
 
 func (c *ConfigImpl) LogLevel() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VLoglevel
 }
 
+func (c *ConfigImpl) LogLevels() map[string]string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
+	result := make(map[string]string, len(c.VLogLevels))
+	for name, level := range c.VLogLevels {
+		result[name] = level
+	}
+	return result
+}
+
+func (c *ConfigImpl) LogSamplingBurst() uint {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
+	return c.VLogSamplingBurst
+}
+
+func (c *ConfigImpl) LogSamplingPeriodSeconds() uint {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
+	return c.VLogSamplingPeriod
+}
+
+func (c *ConfigImpl) LogSamplingThereafter() uint {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
+	return c.VLogSamplingThereafter
+}
+
+func (c *ConfigImpl) LogRedactFields() []string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
+	return append([]string{}, c.VLogRedactFields...)
+}
+
+func (c *ConfigImpl) LogRedactPatterns() []*regexp.Regexp {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
+	return append([]*regexp.Regexp{}, c.VLogRedactPatterns...)
+}
+
+// BasicAuthUsers is parsed on each call, so it includes password hashes obtained from vault after configuration setup.
+func (c *ConfigImpl) BasicAuthUsers() []repository.BasicAuthUser {
+	// after validate, this can only fail if vault has put invalid values, which the caller will notice
+	users, _ := parseBasicAuthUsers(c.rawValue(KeyBasicAuthUsers), c.rawValue(KeyBasicAuthPasswordHashes))
+	return users
+}
+
+func (c *ConfigImpl) ApiKeys() []repository.ApiKey {
+	apiKeys, _ := parseApiKeys(c.rawValue(KeyApiKeys))
+	return apiKeys
+}
+
 func (c *ConfigImpl) VaultServer() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VVaultServer
 }
 
 func (c *ConfigImpl) VaultCertificateFile() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VVaultCertFile
 }
 
 func (c *ConfigImpl) VaultSecretPath() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VVaultSecretPath
 }
 
 func (c *ConfigImpl) LocalVault() bool {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VLocalVaultToken != ""
 }
 
 func (c *ConfigImpl) LocalVaultToken() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VLocalVaultToken
 }
 
 func (c *ConfigImpl) VaultKubernetesRole() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VVaultK8sRole
 }
 
 func (c *ConfigImpl) VaultKubernetesTokenPath() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VVaultK8sTokenPath
 }
 
 func (c *ConfigImpl) VaultKubernetesBackend() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VVaultK8sBackend
 }
 
 func (c *ConfigImpl) CorsAllowOrigin() string {
+	c.valuesLock.RLock()
+	defer c.valuesLock.RUnlock()
+
 	return c.VCorsAllowOrigin
 }
@@ -4,18 +4,37 @@ package config
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	auacornapi "github.com/StephanHCB/go-autumn-acorn-registry/api"
 	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
 	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	auzerolog "github.com/StephanHCB/go-autumn-logging-zerolog"
 	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"regexp"
+	"strings"
+	"sync"
 )
 
 type ConfigImpl struct {
 	Logging           repository.Logging
 	validationContext context.Context
 
+	// all config items and the keys that may change during Reload()
+	configItems    []auconfigapi.ConfigItem
+	reloadableKeys map[string]bool
+
+	// serializes Reload()
+	reloadLock sync.Mutex
+	// protects the global configuration values, which Reload() modifies while reading and validating them
+	rawLock sync.RWMutex
+	// protects the parsed values below and the custom configuration, which are replaced by Reload()
+	valuesLock sync.RWMutex
+
+	subscribersLock sync.Mutex
+	subscribers     []repository.ConfigChangeSubscriber
+
 	// place to store the parsed and validated config values for quick no-parse access
 	VApplicationName   string
 	VServerAddress     string
@@ -32,9 +51,18 @@ type ConfigImpl struct {
 	VVaultK8sBackend   string
 	VCorsAllowOrigin   string
 
+	VLogLevels map[string]string
+
 	VServerPortValue  uint16
 	VMetricsPortValue uint16
 
+	VLogSamplingBurst      uint
+	VLogSamplingPeriod     uint
+	VLogSamplingThereafter uint
+
+	VLogRedactFields   []string
+	VLogRedactPatterns []*regexp.Regexp
+
 	CustomConfiguration repository.CustomConfiguration
 }
 
@@ -66,6 +94,14 @@ func (r *ConfigImpl) construct(customConfig repository.CustomConfiguration, addi
 	for _, item := range additionalConfigItems {
 		allConfigItems = append(allConfigItems, item)
 	}
+	r.configItems = allConfigItems
+
+	r.reloadableKeys = make(map[string]bool)
+	reloadableLock.Lock()
+	for _, item := range allConfigItems {
+		r.reloadableKeys[item.Key] = reloadableItems[item.Key]
+	}
+	reloadableLock.Unlock()
 
 	warnFunc := func(message string) {
 		if r.Logging != nil && r.validationContext != nil {
@@ -91,33 +127,185 @@ func (r *ConfigImpl) Validate(ctx context.Context) error {
 }
 
 func (r *ConfigImpl) ObtainValuesNeededForLogging() {
+	r.valuesLock.Lock()
+	defer r.valuesLock.Unlock()
+
 	r.VApplicationName = auconfigenv.Get(KeyApplicationName)
 	r.VEnvironment = auconfigenv.Get(KeyEnvironment)
 	r.VPlatform = auconfigenv.Get(KeyPlatform)
 	r.VLogstyle = auconfigenv.Get(KeyLogstyle)
 	r.VLoglevel = auconfigenv.Get(KeyLogLevel)
+	r.VLogLevels = parseLogLevels(auconfigenv.Get(KeyLogLevels))
+
+	// not validated yet, so fall back to switching off sampling if the values cannot be parsed
+	r.VLogSamplingBurst, _ = auconfigenv.AToUint(auconfigenv.Get(KeyLogSamplingBurst))
+	r.VLogSamplingPeriod, _ = auconfigenv.AToUint(auconfigenv.Get(KeyLogSamplingPeriod))
+	r.VLogSamplingThereafter, _ = auconfigenv.AToUint(auconfigenv.Get(KeyLogSamplingThereafter))
+
+	r.VLogRedactFields = parseRedactFields(auconfigenv.Get(KeyLogRedactFields))
+	r.VLogRedactPatterns = parseRedactPatterns(auconfigenv.Get(KeyLogRedactPatterns))
 }
 
 func (r *ConfigImpl) ObtainPredefinedValues() {
-	r.VApplicationName = auconfigenv.Get(KeyApplicationName)
-	r.VServerAddress = auconfigenv.Get(KeyServerAddress)
-	r.VEnvironment = auconfigenv.Get(KeyEnvironment)
-	r.VPlatform = auconfigenv.Get(KeyPlatform)
-	r.VLogstyle = auconfigenv.Get(KeyLogstyle)
-	r.VLoglevel = auconfigenv.Get(KeyLogLevel)
-	r.VVaultServer = auconfigenv.Get(KeyVaultServer)
-	r.VVaultCertFile = auconfigenv.Get(KeyVaultCertificateFile)
-	r.VVaultSecretPath = auconfigenv.Get(KeyVaultSecretPath)
-	r.VLocalVaultToken = auconfigenv.Get(KeyLocalVaultToken)
-	r.VVaultK8sRole = auconfigenv.Get(KeyVaultKubernetesRole)
-	r.VVaultK8sTokenPath = auconfigenv.Get(KeyVaultKubernetesTokenPath)
-	r.VVaultK8sBackend = auconfigenv.Get(KeyVaultKubernetesBackend)
-	r.VCorsAllowOrigin = auconfigenv.Get(KeyCorsAllowOrigin)
+	r.valuesLock.Lock()
+	defer r.valuesLock.Unlock()
+
+	r.obtainPredefinedValuesLocked(auconfigenv.Get)
+}
+
+func (r *ConfigImpl) obtainPredefinedValuesLocked(get func(key string) string) {
+	r.VApplicationName = get(KeyApplicationName)
+	r.VServerAddress = get(KeyServerAddress)
+	r.VEnvironment = get(KeyEnvironment)
+	r.VPlatform = get(KeyPlatform)
+	r.VLogstyle = get(KeyLogstyle)
+	r.VLoglevel = get(KeyLogLevel)
+	r.VLogLevels = parseLogLevels(get(KeyLogLevels))
+	r.VVaultServer = get(KeyVaultServer)
+	r.VVaultCertFile = get(KeyVaultCertificateFile)
+	r.VVaultSecretPath = get(KeyVaultSecretPath)
+	r.VLocalVaultToken = get(KeyLocalVaultToken)
+	r.VVaultK8sRole = get(KeyVaultKubernetesRole)
+	r.VVaultK8sTokenPath = get(KeyVaultKubernetesTokenPath)
+	r.VVaultK8sBackend = get(KeyVaultKubernetesBackend)
+	r.VCorsAllowOrigin = get(KeyCorsAllowOrigin)
 
 	// after validate, these cannot fail any more
-	vServerPortValue, _ := auconfigenv.AToUint(auconfigenv.Get(KeyServerPort))
+	vServerPortValue, _ := auconfigenv.AToUint(get(KeyServerPort))
 	r.VServerPortValue = uint16(vServerPortValue)
 
-	vMetricsPortValue, _ := auconfigenv.AToUint(auconfigenv.Get(KeyMetricsPort))
+	vMetricsPortValue, _ := auconfigenv.AToUint(get(KeyMetricsPort))
 	r.VMetricsPortValue = uint16(vMetricsPortValue)
+
+	r.VLogSamplingBurst, _ = auconfigenv.AToUint(get(KeyLogSamplingBurst))
+	r.VLogSamplingPeriod, _ = auconfigenv.AToUint(get(KeyLogSamplingPeriod))
+	r.VLogSamplingThereafter, _ = auconfigenv.AToUint(get(KeyLogSamplingThereafter))
+
+	r.VLogRedactFields = parseRedactFields(get(KeyLogRedactFields))
+	r.VLogRedactPatterns = parseRedactPatterns(get(KeyLogRedactPatterns))
+}
+
+// ConfigItems returns all configuration items known to this configuration, including the additional ones.
+func (r *ConfigImpl) ConfigItems() []auconfigapi.ConfigItem {
+	return r.configItems
+}
+
+// IsReloadable returns true if the configuration item with the given key may change during Reload().
+func (r *ConfigImpl) IsReloadable(key string) bool {
+	return r.reloadableKeys[key]
+}
+
+// parseLogLevels parses a list of the form "name1=LEVEL1,name2=LEVEL2", skipping malformed entries
+func parseLogLevels(value string) map[string]string {
+	result := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		nameAndLevel := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(nameAndLevel) == 2 && nameAndLevel[0] != "" && nameAndLevel[1] != "" {
+			result[nameAndLevel[0]] = nameAndLevel[1]
+		}
+	}
+	return result
+}
+
+// parseRedactFields parses a comma separated list of field names, "-" means none
+func parseRedactFields(value string) []string {
+	result := make([]string, 0)
+	for _, entry := range strings.Split(value, ",") {
+		name := strings.ToLower(strings.TrimSpace(entry))
+		if name != "" && name != "-" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// parseRedactPatterns parses a json array of regular expressions, skipping invalid entries
+//
+// Invalid entries are reported during validation, but logging is set up before that.
+func parseRedactPatterns(value string) []*regexp.Regexp {
+	result := make([]*regexp.Regexp, 0)
+	patterns := make([]string, 0)
+	if err := json.Unmarshal([]byte(value), &patterns); err != nil {
+		return result
+	}
+	for _, pattern := range patterns {
+		if compiled, err := regexp.Compile(pattern); err == nil {
+			result = append(result, compiled)
+		}
+	}
+	return result
+}
+
+func validateRedactPatterns(key string) error {
+	patterns := make([]string, 0)
+	if err := json.Unmarshal([]byte(auconfigenv.Get(key)), &patterns); err != nil {
+		return fmt.Errorf("value is not a json array of strings: %s", err.Error())
+	}
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("value contains an invalid regular expression: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// parseBasicAuthUsers parses the users and fills in the password hashes from the separate map
+func parseBasicAuthUsers(usersJson string, passwordHashesJson string) ([]repository.BasicAuthUser, error) {
+	users := make([]repository.BasicAuthUser, 0)
+	if err := json.Unmarshal([]byte(usersJson), &users); err != nil {
+		return nil, fmt.Errorf("must be a json array of basic auth users: %s", err.Error())
+	}
+	passwordHashes := make(map[string]string)
+	if err := json.Unmarshal([]byte(passwordHashesJson), &passwordHashes); err != nil {
+		return nil, fmt.Errorf("must be a json object of password hashes by username: %s", err.Error())
+	}
+
+	seen := make(map[string]bool)
+	for i := range users {
+		if users[i].Username == "" {
+			return nil, fmt.Errorf("basic auth user #%d has no username", i+1)
+		}
+		if strings.Contains(users[i].Username, ".") {
+			// vault configKey %s.<username> would be split at the dot
+			return nil, fmt.Errorf("basic auth user '%s' must not contain '.', or its password hash cannot be set in %s", users[i].Username, KeyBasicAuthPasswordHashes)
+		}
+		if seen[users[i].Username] {
+			return nil, fmt.Errorf("basic auth user '%s' is listed more than once", users[i].Username)
+		}
+		seen[users[i].Username] = true
+		if users[i].PasswordHash == "" {
+			users[i].PasswordHash = passwordHashes[users[i].Username]
+		}
+	}
+	return users, nil
+}
+
+func validateBasicAuthUsers(key string) error {
+	_, err := parseBasicAuthUsers(auconfigenv.Get(key), "{}")
+	return err
+}
+
+func validateBasicAuthPasswordHashes(key string) error {
+	_, err := parseBasicAuthUsers("[]", auconfigenv.Get(key))
+	return err
+}
+
+var apiKeyHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func parseApiKeys(apiKeysJson string) ([]repository.ApiKey, error) {
+	apiKeys := make([]repository.ApiKey, 0)
+	if err := json.Unmarshal([]byte(apiKeysJson), &apiKeys); err != nil {
+		return nil, fmt.Errorf("must be a json array of api keys: %s", err.Error())
+	}
+	for i, apiKey := range apiKeys {
+		if !apiKeyHashPattern.MatchString(apiKey.KeyHash) {
+			return nil, fmt.Errorf("api key #%d must have a keyHash of 64 lowercase hex digits", i+1)
+		}
+	}
+	return apiKeys, nil
+}
+
+func validateApiKeys(key string) error {
+	_, err := parseApiKeys(auconfigenv.Get(key))
+	return err
 }
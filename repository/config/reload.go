@@ -0,0 +1,222 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"gopkg.in/yaml.v2"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func (r *ConfigImpl) Subscribe(subscriber repository.ConfigChangeSubscriber) {
+	r.subscribersLock.Lock()
+	defer r.subscribersLock.Unlock()
+
+	r.subscribers = append(r.subscribers, subscriber)
+}
+
+// Reload re-reads the configuration, and applies it if it validates.
+//
+// Reloads are serialized. The global configuration values are only modified while holding rawLock, and
+// restored if validation fails, so concurrent readers only ever see validated values. The parsed values
+// and a fresh copy of the custom configuration are then swapped in together under valuesLock.
+func (r *ConfigImpl) Reload(ctx context.Context) error {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	staged, events, err := r.readAndValidate(ctx)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+
+	get := func(key string) string {
+		return staged[key]
+	}
+	custom := obtainCustomCopy(r.Custom(), get)
+
+	r.valuesLock.Lock()
+	r.obtainPredefinedValuesLocked(get)
+	r.CustomConfiguration = custom
+	r.valuesLock.Unlock()
+
+	for _, event := range events {
+		r.Logging.Logger().Ctx(ctx).Info().Printf("configuration field %s changed at runtime", event.Key)
+	}
+
+	r.notifySubscribers(ctx, events)
+	return nil
+}
+
+// readAndValidate returns the new validated values and the changes, leaving the values unchanged on error
+func (r *ConfigImpl) readAndValidate(ctx context.Context) (map[string]string, []repository.ConfigChangeEvent, error) {
+	r.rawLock.Lock()
+	defer r.rawLock.Unlock()
+
+	previous := r.snapshot()
+
+	if err := r.reread(); err != nil {
+		r.restore(previous)
+		r.Logging.Logger().Ctx(ctx).Error().WithErr(err).Print("failed to re-read configuration, keeping previous values")
+		return nil, nil, err
+	}
+
+	events := make([]repository.ConfigChangeEvent, 0)
+	for _, it := range r.configItems {
+		oldValue := previous[it.Key]
+		newValue := auconfigenv.Get(it.Key)
+		if oldValue == newValue {
+			continue
+		}
+
+		if r.reloadableKeys[it.Key] {
+			events = append(events, repository.ConfigChangeEvent{
+				Key:      it.Key,
+				OldValue: oldValue,
+				NewValue: newValue,
+			})
+		} else {
+			r.Logging.Logger().Ctx(ctx).Warn().Printf("configuration field %s changed, but cannot be changed at runtime - ignoring new value until restart", it.EnvName)
+			auconfigenv.Set(it.Key, oldValue)
+		}
+	}
+
+	if len(events) == 0 {
+		return nil, nil, nil
+	}
+
+	if err := r.Validate(ctx); err != nil {
+		r.restore(previous)
+		r.Logging.Logger().Ctx(ctx).Error().WithErr(err).Print("failed to validate reloaded configuration, keeping previous values. See log messages above for individual errors")
+		return nil, nil, err
+	}
+
+	return r.snapshot(), events, nil
+}
+
+// reread reads the local configuration file and the environment like auconfigenv.Read, but only for the known keys.
+//
+// auconfigenv.Read adds the unknown keys to a list that is never cleared, and validation warns about all of them,
+// so they would pile up and be reported again on every reload. They were reported at startup already.
+func (r *ConfigImpl) reread() error {
+	fileValues := make(map[string]string)
+	yamlFile, err := os.ReadFile(auconfigenv.LocalConfigFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading local configuration yaml file %s: %s", auconfigenv.LocalConfigFileName, err.Error())
+	}
+	if err == nil {
+		if err := yaml.UnmarshalStrict(yamlFile, &fileValues); err != nil {
+			return fmt.Errorf("error parsing local configuration flat yaml file %s (both keys and values must be strings): %s", auconfigenv.LocalConfigFileName, err.Error())
+		}
+	}
+
+	for _, it := range r.configItems {
+		if value, ok := fileValues[it.Key]; ok {
+			auconfigenv.Set(it.Key, value)
+		}
+		if value, ok := os.LookupEnv(envName(it)); ok {
+			auconfigenv.Set(it.Key, value)
+		}
+	}
+	return nil
+}
+
+// envName is the environment variable of a configuration item, with the same fallback as auconfigenv
+func envName(it auconfigapi.ConfigItem) string {
+	if it.EnvName != "" {
+		return it.EnvName
+	}
+	return "CONFIG_" + strings.ToUpper(nonAlphanumeric.ReplaceAllString(it.Key, "_"))
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]`)
+
+// rawValue reads a configuration value that has no parsed field, without racing a Reload
+func (r *ConfigImpl) rawValue(key string) string {
+	r.rawLock.RLock()
+	defer r.rawLock.RUnlock()
+
+	return auconfigenv.Get(key)
+}
+
+// obtainCustomCopy fills a copy of the custom configuration, so goroutines still reading the current one are not affected.
+//
+// Custom configurations that are not a pointer to a struct cannot be copied, and are filled in place.
+func obtainCustomCopy(current repository.CustomConfiguration, get func(key string) string) repository.CustomConfiguration {
+	value := reflect.ValueOf(current)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		current.Obtain(get)
+		return current
+	}
+
+	fresh := reflect.New(value.Elem().Type())
+	fresh.Elem().Set(value.Elem())
+	custom := fresh.Interface().(repository.CustomConfiguration)
+	custom.Obtain(get)
+	return custom
+}
+
+func (r *ConfigImpl) StartReloading(ctx context.Context, interval time.Duration, watchFile bool) {
+	lastModified := localConfigFileModTime()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if watchFile {
+					modified := localConfigFileModTime()
+					if modified.Equal(lastModified) {
+						continue
+					}
+					lastModified = modified
+				}
+
+				// errors are logged by Reload, and the previous configuration stays in effect
+				_ = r.Reload(ctx)
+			}
+		}
+	}()
+}
+
+func (r *ConfigImpl) snapshot() map[string]string {
+	values := make(map[string]string)
+	for _, it := range r.configItems {
+		values[it.Key] = auconfigenv.Get(it.Key)
+	}
+	return values
+}
+
+func (r *ConfigImpl) restore(values map[string]string) {
+	for key, value := range values {
+		auconfigenv.Set(key, value)
+	}
+}
+
+func (r *ConfigImpl) notifySubscribers(ctx context.Context, events []repository.ConfigChangeEvent) {
+	r.subscribersLock.Lock()
+	subscribers := make([]repository.ConfigChangeSubscriber, len(r.subscribers))
+	copy(subscribers, r.subscribers)
+	r.subscribersLock.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(ctx, events)
+	}
+}
+
+func localConfigFileModTime() time.Time {
+	info, err := os.Stat(auconfigenv.LocalConfigFileName)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
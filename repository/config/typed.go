@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"reflect"
+)
+
+// CustomAs gives you type safe access to your custom configuration value object.
+//
+// Returns an error instead of panicking if the custom configuration is not of type T.
+//
+// I have found it convenient to call this in the acorn setup and keep the result, rather than
+// calling it every time you need a configuration value. Reload() replaces the custom configuration with
+// a new object though, so if your custom configuration has reloadable values, call this when you need them.
+func CustomAs[T repository.CustomConfiguration](configuration repository.Configuration) (T, error) {
+	var zero T
+	if configuration == nil {
+		return zero, fmt.Errorf("cannot obtain custom configuration as %s: configuration is nil", typeName[T]())
+	}
+	return customAs[T](configuration.Custom())
+}
+
+func customAs[T repository.CustomConfiguration](current repository.CustomConfiguration) (T, error) {
+	custom, ok := current.(T)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("cannot obtain custom configuration as %s: it is of type %T", typeName[T](), current)
+	}
+	return custom, nil
+}
+
+// typeName also works for interface types, where %T of the zero value would just print <nil>
+func typeName[T any]() string {
+	return reflect.TypeOf((*T)(nil)).Elem().String()
+}
+
+// TypedConfigImpl is a configuration that knows the type of its custom configuration.
+//
+// It is a full configuration Acorn, so you can use it anywhere you would use the result of New().
+type TypedConfigImpl[T repository.CustomConfiguration] struct {
+	*ConfigImpl
+}
+
+// NewTyped initially creates the instance with no logging (circular dependency), just like New().
+//
+// The result also gives you type safe access to your custom configuration through Typed().
+func NewTyped[T repository.CustomConfiguration](customConfig T, additionalConfigItems []auconfigapi.ConfigItem) *TypedConfigImpl[T] {
+	instance := &ConfigImpl{}
+	instance.construct(customConfig, additionalConfigItems)
+	return &TypedConfigImpl[T]{
+		ConfigImpl: instance,
+	}
+}
+
+// Typed gives you type safe access to your custom configuration value object.
+//
+// Returns an error instead of panicking if the custom configuration is nil or not of type T.
+func (r *TypedConfigImpl[T]) Typed() (T, error) {
+	return customAs[T](r.Custom())
+}
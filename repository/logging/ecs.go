@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/StephanHCB/go-autumn-logging-zerolog/implementation/leveledlogging"
+	auloggingapi "github.com/StephanHCB/go-autumn-logging/api"
+	"github.com/StephanHCB/go-backend-service-common/repository/buildinfo"
+	"github.com/rs/zerolog"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// EcsVersion is the version of the ECS logging schema we write, same as the Spring services
+var EcsVersion = "1.2.0"
+
+const (
+	ErrorTypeFieldName       = "error.type"
+	ErrorStackTraceFieldName = "error.stack_trace"
+)
+
+// withEcsFields adds the ECS fields that do not change while the service is running
+func (l *LoggingImpl) withEcsFields(logContext zerolog.Context, serviceName string) zerolog.Context {
+	logContext = logContext.
+		Str("ecs.version", EcsVersion).
+		Str("service.name", serviceName).
+		Str("event.dataset", serviceName+".log").
+		Int("process.pid", os.Getpid())
+
+	if environment := l.Configuration.Environment(); environment != "" {
+		logContext = logContext.Str("service.environment", environment)
+	}
+	if platform := l.Configuration.Platform(); platform != "" {
+		logContext = logContext.Str("labels.platform", platform)
+	}
+	if version := buildinfo.ServiceVersion(); version != "" {
+		logContext = logContext.Str("service.version", version)
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		logContext = logContext.Str("host.hostname", hostname)
+	}
+	return logContext
+}
+
+// --- structured error fields ---
+
+// ecsLogging wraps the logging implementation so WithErr also writes error.type and error.stack_trace
+type ecsLogging struct {
+	wrapped auloggingapi.LoggingImplementation
+}
+
+type ecsContextAwareLogging struct {
+	wrapped auloggingapi.ContextAwareLoggingImplementation
+}
+
+type ecsLeveledLogging struct {
+	wrapped auloggingapi.LeveledLoggingImplementation
+	err     error
+}
+
+func withEcsErrors(wrapped auloggingapi.LoggingImplementation) auloggingapi.LoggingImplementation {
+	if _, ok := wrapped.(*ecsLogging); ok {
+		return wrapped
+	}
+	return &ecsLogging{wrapped: wrapped}
+}
+
+func (l *ecsLogging) Ctx(ctx context.Context) auloggingapi.ContextAwareLoggingImplementation {
+	return &ecsContextAwareLogging{wrapped: l.wrapped.Ctx(ctx)}
+}
+
+func (l *ecsLogging) NoCtx() auloggingapi.ContextAwareLoggingImplementation {
+	return &ecsContextAwareLogging{wrapped: l.wrapped.NoCtx()}
+}
+
+func (l *ecsContextAwareLogging) Trace() auloggingapi.LeveledLoggingImplementation {
+	return &ecsLeveledLogging{wrapped: l.wrapped.Trace()}
+}
+
+func (l *ecsContextAwareLogging) Debug() auloggingapi.LeveledLoggingImplementation {
+	return &ecsLeveledLogging{wrapped: l.wrapped.Debug()}
+}
+
+func (l *ecsContextAwareLogging) Info() auloggingapi.LeveledLoggingImplementation {
+	return &ecsLeveledLogging{wrapped: l.wrapped.Info()}
+}
+
+func (l *ecsContextAwareLogging) Warn() auloggingapi.LeveledLoggingImplementation {
+	return &ecsLeveledLogging{wrapped: l.wrapped.Warn()}
+}
+
+func (l *ecsContextAwareLogging) Error() auloggingapi.LeveledLoggingImplementation {
+	return &ecsLeveledLogging{wrapped: l.wrapped.Error()}
+}
+
+func (l *ecsContextAwareLogging) Fatal() auloggingapi.LeveledLoggingImplementation {
+	return &ecsLeveledLogging{wrapped: l.wrapped.Fatal()}
+}
+
+func (l *ecsContextAwareLogging) Panic() auloggingapi.LeveledLoggingImplementation {
+	return &ecsLeveledLogging{wrapped: l.wrapped.Panic()}
+}
+
+// WithErr only remembers the error, the structured error fields are added when the entry is written
+func (l *ecsLeveledLogging) WithErr(err error) auloggingapi.LeveledLoggingImplementation {
+	l.wrapped = l.wrapped.WithErr(err)
+	l.err = err
+	return l
+}
+
+func (l *ecsLeveledLogging) With(key string, value string) auloggingapi.LeveledLoggingImplementation {
+	l.wrapped = l.wrapped.With(key, value)
+	return l
+}
+
+func (l *ecsLeveledLogging) Print(v ...interface{}) {
+	if l.err != nil && enabled(l.wrapped) {
+		l.wrapped = l.wrapped.
+			With(ErrorTypeFieldName, errorType(l.err)).
+			With(ErrorStackTraceFieldName, stackTrace(l.err))
+	}
+	l.wrapped.Print(v...)
+}
+
+func (l *ecsLeveledLogging) Printf(format string, v ...interface{}) {
+	if l.err != nil && enabled(l.wrapped) {
+		l.wrapped = l.wrapped.
+			With(ErrorTypeFieldName, errorType(l.err)).
+			With(ErrorStackTraceFieldName, stackTrace(l.err))
+	}
+	l.wrapped.Printf(format, v...)
+}
+
+// enabled is false if zerolog is going to discard the entry because of its level
+func enabled(wrapped auloggingapi.LeveledLoggingImplementation) bool {
+	if zerologLeveled, ok := wrapped.(*leveledlogging.ZerologLeveledLoggingImplementation); ok {
+		return zerologLeveled.LeveledLogEvent.Enabled()
+	}
+	return true
+}
+
+// errorType is the type of the error, looking through errors that were just wrapped with fmt.Errorf("...%w", err)
+func errorType(err error) string {
+	for {
+		typeName := fmt.Sprintf("%T", err)
+		if typeName != "*fmt.wrapError" {
+			return typeName
+		}
+		unwrapped := errors.Unwrap(err)
+		if unwrapped == nil {
+			return typeName
+		}
+		err = unwrapped
+	}
+}
+
+// stackTrace uses the stack trace of the error if it has one (e.g. github.com/pkg/errors),
+// and otherwise the stack of the code that logged the error.
+func stackTrace(err error) string {
+	if detailed := fmt.Sprintf("%+v", err); detailed != err.Error() {
+		return detailed
+	}
+
+	pc := make([]uintptr, 32)
+	// skip runtime.Callers, stackTrace, Print or Printf
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+
+	builder := strings.Builder{}
+	for {
+		frame, more := frames.Next()
+		builder.WriteString(frame.Function)
+		builder.WriteString("\n\t")
+		builder.WriteString(fmt.Sprintf("%s:%d\n", frame.File, frame.Line))
+		if !more {
+			break
+		}
+	}
+	return builder.String()
+}
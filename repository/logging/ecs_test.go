@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/StephanHCB/go-autumn-logging-zerolog/implementation/leveledlogging"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/repository/buildinfo"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestEcs_Fields(t *testing.T) {
+	docs.Description("json log entries contain the ECS fields the dashboards rely on")
+
+	t.Setenv(config.KeyEnvironment, "test")
+	t.Setenv(config.KeyPlatform, "shop")
+	cut, buffer, _ := tstSetupCutWithLevels(t, "INFO", "")
+	buildinfo.Version = "1.2.3"
+	defer func() { buildinfo.Version = "" }()
+
+	logger := cut.withEcsFields(zerolog.New(buffer).With(), "room-service").Logger()
+	logger.Info().Msg("hello")
+
+	entry := tstParseEntry(t, buffer)
+	hostname, _ := os.Hostname()
+	require.Equal(t, EcsVersion, entry["ecs.version"])
+	require.Equal(t, "room-service", entry["service.name"])
+	require.Equal(t, "room-service.log", entry["event.dataset"])
+	require.Equal(t, "test", entry["service.environment"])
+	require.Equal(t, "shop", entry["labels.platform"])
+	require.Equal(t, "1.2.3", entry["service.version"])
+	require.Equal(t, hostname, entry["host.hostname"])
+	require.Equal(t, float64(os.Getpid()), entry["process.pid"])
+}
+
+func TestEcs_ErrorFields(t *testing.T) {
+	docs.Description("logging an error adds error.type and error.stack_trace, but only if the entry is written")
+
+	buffer := new(bytes.Buffer)
+	logger := zerolog.New(buffer)
+	cut := &ecsLeveledLogging{wrapped: &leveledlogging.ZerologLeveledLoggingImplementation{
+		LeveledLogEvent: logger.Error(),
+		Level:           "ERROR",
+	}}
+
+	err := fmt.Errorf("failed to read config: %w", &fs.PathError{Op: "open", Path: "config.yaml", Err: fs.ErrNotExist})
+	cut.WithErr(err).Print("oops")
+
+	entry := tstParseEntry(t, buffer)
+	require.Equal(t, "*fs.PathError", entry[ErrorTypeFieldName])
+	require.Contains(t, entry[ErrorStackTraceFieldName], "logging.TestEcs_ErrorFields")
+
+	infoLogger := logger.Level(zerolog.InfoLevel)
+	wrapped := &leveledlogging.ZerologLeveledLoggingImplementation{
+		LeveledLogEvent: infoLogger.Debug(),
+		Level:           "DEBUG",
+	}
+	cut = &ecsLeveledLogging{wrapped: wrapped}
+	cut.WithErr(err).Printf("filtered %s", "oops")
+	require.NotContains(t, wrapped.Additional, ErrorStackTraceFieldName)
+}
+
+// --- helpers ---
+
+func tstParseEntry(t *testing.T, buffer *bytes.Buffer) map[string]interface{} {
+	entry := make(map[string]interface{})
+	require.Nil(t, json.Unmarshal(buffer.Bytes(), &entry))
+	return entry
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"github.com/rs/zerolog"
+	"io"
+)
+
+// LoggerNameFieldName is the field that holds the logger name, which can have its own log level
+var LoggerNameFieldName = "log.logger"
+
+// levelFilterWriter drops log entries that are below the level for their logger name.
+//
+// The zerolog global level is set to the lowest level of any logger name, so entries
+// for logger names with a lower level than the global level are actually produced.
+type levelFilterWriter struct {
+	next io.Writer
+	impl *LoggingImpl
+}
+
+func (l *LoggingImpl) levelFilter(next io.Writer) io.Writer {
+	return &levelFilterWriter{
+		next: next,
+		impl: l,
+	}
+}
+
+func (w *levelFilterWriter) Write(p []byte) (int, error) {
+	return w.next.Write(p)
+}
+
+func (w *levelFilterWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	thresholds := w.impl.thresholds.Load()
+	if thresholds != nil && len(thresholds.named) > 0 && level != zerolog.NoLevel {
+		threshold := thresholds.root
+		if name, ok := loggerName(p); ok {
+			if namedThreshold, ok := thresholds.named[name]; ok {
+				threshold = namedThreshold
+			}
+		}
+		if level < threshold {
+			// pretend to have written the entry
+			return len(p), nil
+		}
+	}
+	return writeLevel(w.next, level, p)
+}
+
+// writeLevel passes on the level if the next writer is interested in it
+func writeLevel(next io.Writer, level zerolog.Level, p []byte) (int, error) {
+	if levelWriter, ok := next.(zerolog.LevelWriter); ok {
+		return levelWriter.WriteLevel(level, p)
+	}
+	return next.Write(p)
+}
+
+// loggerName extracts the logger name from a json encoded log entry without fully parsing it
+func loggerName(p []byte) (string, bool) {
+	return jsonStringField(p, LoggerNameFieldName)
+}
+
+// jsonStringField extracts a string field from a json encoded log entry without fully parsing it
+//
+// Escape sequences are left as they are, which is good enough for comparing values.
+func jsonStringField(p []byte, fieldName string) (string, bool) {
+	marker := []byte(`"` + fieldName + `":"`)
+	start := bytes.Index(p, marker)
+	if start < 0 {
+		return "", false
+	}
+	start += len(marker)
+	for i := start; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++ // skip escaped character
+		case '"':
+			return string(p[start:i]), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/rs/zerolog"
+	"strings"
+	"time"
+)
+
+// runtimeLevel is a log level that was changed at runtime for one logger name
+type runtimeLevel struct {
+	level       zerolog.Level
+	revertTimer *time.Timer
+	revertAt    time.Time
+}
+
+// levelThresholds is an immutable snapshot of the effective levels, read by the level filter for every log entry
+type levelThresholds struct {
+	root  zerolog.Level
+	named map[string]zerolog.Level
+}
+
+func (l *LoggingImpl) Levels() map[string]repository.LogLevelInfo {
+	l.levelLock.Lock()
+	defer l.levelLock.Unlock()
+
+	result := make(map[string]repository.LogLevelInfo)
+	for _, name := range l.loggerNames() {
+		info := repository.LogLevelInfo{}
+		if configured, ok := l.configuredLevel(name); ok {
+			info.ConfiguredLevel = levelName(configured)
+		}
+		info.EffectiveLevel = levelName(l.effectiveLevel(name))
+		if runtime, ok := l.runtimeLevels[name]; ok && runtime.revertTimer != nil {
+			revertAt := runtime.revertAt
+			info.RevertAt = &revertAt
+		}
+		result[name] = info
+	}
+	return result
+}
+
+func (l *LoggingImpl) SetLevel(_ context.Context, name string, level string, ttl time.Duration) error {
+	if name == "" {
+		return errors.New("logger name must not be empty")
+	}
+
+	l.levelLock.Lock()
+	defer l.levelLock.Unlock()
+
+	if previous, ok := l.runtimeLevels[name]; ok && previous.revertTimer != nil {
+		previous.revertTimer.Stop()
+	}
+
+	if level == "" {
+		delete(l.runtimeLevels, name)
+		l.applyLevels()
+		return nil
+	}
+
+	parsed, err := zerolog.ParseLevel(level)
+	if err != nil || parsed == zerolog.NoLevel {
+		return fmt.Errorf("invalid log level '%s'", level)
+	}
+
+	runtime := &runtimeLevel{
+		level: parsed,
+	}
+	if ttl > 0 {
+		runtime.revertAt = time.Now().Add(ttl)
+		runtime.revertTimer = time.AfterFunc(ttl, func() {
+			l.revertLevel(name, runtime)
+		})
+	}
+	l.runtimeLevels[name] = runtime
+	l.applyLevels()
+	return nil
+}
+
+// revertLevel runs after the ttl, when the original request context is long gone
+func (l *LoggingImpl) revertLevel(name string, runtime *runtimeLevel) {
+	l.levelLock.Lock()
+	defer l.levelLock.Unlock()
+
+	if l.runtimeLevels[name] != runtime {
+		// level was changed again in the meantime
+		return
+	}
+
+	delete(l.runtimeLevels, name)
+	l.applyLevels()
+	l.Logger().NoCtx().Info().Printf("log level of %s reverted to %s", name, levelName(l.effectiveLevel(name)))
+}
+
+// configuredLevelsChanged is called when the configuration was reloaded with new log levels.
+//
+// Levels set at runtime stay in effect until they are reset or reverted.
+func (l *LoggingImpl) configuredLevelsChanged(ctx context.Context) {
+	l.levelLock.Lock()
+	defer l.levelLock.Unlock()
+
+	l.applyLevels()
+	l.Logger().Ctx(ctx).Info().Printf("log level changed to %s", levelName(l.effectiveLevel(repository.RootLoggerName)))
+}
+
+// applyLevels sets the zerolog global level low enough for all logger names, and updates the level filter.
+//
+// Must be called with levelLock held.
+func (l *LoggingImpl) applyLevels() {
+	thresholds := &levelThresholds{
+		root:  l.effectiveLevel(repository.RootLoggerName),
+		named: make(map[string]zerolog.Level),
+	}
+
+	globalLevel := thresholds.root
+	for _, name := range l.loggerNames() {
+		if name == repository.RootLoggerName {
+			continue
+		}
+		level := l.effectiveLevel(name)
+		thresholds.named[name] = level
+		if level < globalLevel {
+			globalLevel = level
+		}
+	}
+
+	zerolog.SetGlobalLevel(globalLevel)
+	l.thresholds.Store(thresholds)
+}
+
+// loggerNames lists the root logger and all logger names with a configured or runtime level.
+func (l *LoggingImpl) loggerNames() []string {
+	names := []string{repository.RootLoggerName}
+	seen := map[string]bool{repository.RootLoggerName: true}
+	for name := range l.Configuration.LogLevels() {
+		if !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	for name := range l.runtimeLevels {
+		if !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	return names
+}
+
+func (l *LoggingImpl) configuredLevel(name string) (zerolog.Level, bool) {
+	if name == repository.RootLoggerName {
+		return l.logLevel(), true
+	}
+
+	levelStr, ok := l.Configuration.LogLevels()[name]
+	if !ok {
+		return zerolog.NoLevel, false
+	}
+	level, err := zerolog.ParseLevel(levelStr)
+	if err != nil {
+		l.Logger().NoCtx().Warn().WithErr(err).Printf("error parsing log level for %s, ignoring it.", name)
+		return zerolog.NoLevel, false
+	}
+	return level, true
+}
+
+func (l *LoggingImpl) effectiveLevel(name string) zerolog.Level {
+	if runtime, ok := l.runtimeLevels[name]; ok {
+		return runtime.level
+	}
+	if configured, ok := l.configuredLevel(name); ok {
+		return configured
+	}
+	return l.effectiveLevel(repository.RootLoggerName)
+}
+
+func levelName(level zerolog.Level) string {
+	return strings.ToUpper(level.String())
+}
@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	auzerolog "github.com/StephanHCB/go-autumn-logging-zerolog"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLevels_PerLoggerName(t *testing.T) {
+	docs.Description("log levels can be configured per logger name")
+
+	_, buffer, recorder := tstSetupCutWithLevels(t, "INFO", "request.incoming=WARN,vault=DEBUG")
+
+	require.Equal(t, zerolog.DebugLevel, zerolog.GlobalLevel())
+
+	recorder.Info().Str(LoggerNameFieldName, "request.incoming").Msg("incoming info")
+	recorder.Warn().Str(LoggerNameFieldName, "request.incoming").Msg("incoming warn")
+	recorder.Debug().Str(LoggerNameFieldName, "vault").Msg("vault debug")
+	recorder.Debug().Msg("root debug")
+	recorder.Info().Msg("root info")
+
+	actualLog := buffer.String()
+	require.NotContains(t, actualLog, "incoming info")
+	require.Contains(t, actualLog, "incoming warn")
+	require.Contains(t, actualLog, "vault debug")
+	require.NotContains(t, actualLog, "root debug")
+	require.Contains(t, actualLog, "root info")
+}
+
+func TestLevels_RuntimeChange(t *testing.T) {
+	docs.Description("log levels can be changed at runtime and revert after the ttl")
+
+	cut, buffer, recorder := tstSetupCutWithLevels(t, "INFO", "")
+
+	require.Nil(t, cut.SetLevel(context.Background(), "vault", "DEBUG", 50*time.Millisecond))
+	require.Equal(t, "DEBUG", cut.Levels()["vault"].EffectiveLevel)
+	require.NotNil(t, cut.Levels()["vault"].RevertAt)
+	require.Equal(t, "INFO", cut.Levels()[repository.RootLoggerName].EffectiveLevel)
+
+	recorder.Debug().Str(LoggerNameFieldName, "vault").Msg("vault debug")
+	recorder.Debug().Msg("root debug")
+	require.Contains(t, buffer.String(), "vault debug")
+	require.NotContains(t, buffer.String(), "root debug")
+
+	require.Eventually(t, func() bool {
+		_, ok := cut.Levels()["vault"]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, zerolog.InfoLevel, zerolog.GlobalLevel())
+
+	require.NotNil(t, cut.SetLevel(context.Background(), repository.RootLoggerName, "LOUD", 0))
+}
+
+func TestLevels_ConsoleOutput(t *testing.T) {
+	docs.Description("in plaintext logging, the level filter is put in front of the console writer")
+
+	cut, _, _ := tstSetupCutWithLevels(t, "INFO", "vault=WARN")
+	buffer := new(bytes.Buffer)
+	previous := log.Logger
+	defer func() { log.Logger = previous }()
+	log.Logger = zerolog.New(io.Discard).With().Str(auzerolog.RequestIdFieldName, "00000000").Logger()
+
+	cut.filterConsoleOutput(buffer)
+	log.Logger.Info().Str(LoggerNameFieldName, "vault").Msg("vault info")
+	log.Logger.Info().Msg("root info")
+
+	require.NotContains(t, buffer.String(), "vault info")
+	require.Contains(t, buffer.String(), "INF [00000000] root info\n")
+}
+
+// --- helpers ---
+
+func tstSetupCutWithLevels(t *testing.T, logLevel string, logLevels string) (*LoggingImpl, *bytes.Buffer, zerolog.Logger) {
+	t.Setenv(config.KeyLogLevel, logLevel)
+	t.Setenv(config.KeyLogLevels, logLevels)
+	auconfigenv.LocalConfigFileName = "does-not-exist.yaml"
+
+	configuration := config.NewNoAcorn(nil, nil)
+	cut := &LoggingImpl{
+		Configuration: configuration,
+	}
+	cut.SetupForTesting()
+	require.Nil(t, configuration.Assemble(cut))
+
+	cut.setupLevels()
+
+	buffer := new(bytes.Buffer)
+	return cut, buffer, zerolog.New(cut.levelFilter(buffer))
+}
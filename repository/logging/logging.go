@@ -7,52 +7,99 @@ import (
 	"github.com/StephanHCB/go-autumn-logging-zerolog/loggermiddleware"
 	auloggingapi "github.com/StephanHCB/go-autumn-logging/api"
 	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/StephanHCB/go-backend-service-common/repository/metrics"
 	"github.com/StephanHCB/go-backend-service-common/web/middleware/requestid"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"io"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type LoggingImpl struct {
 	Configuration repository.Configuration
-	Metrics       *prometheus.CounterVec
+	// Registerer is used for the logging metrics, leave nil to use metrics.Registerer
+	Registerer     prometheus.Registerer
+	Metrics        *prometheus.CounterVec
+	DroppedMetrics *prometheus.CounterVec
+
+	// log levels per logger name, see level.go
+	levelLock     sync.Mutex
+	runtimeLevels map[string]*runtimeLevel
+	thresholds    atomic.Pointer[levelThresholds]
+
+	// masking of sensitive values, see redaction.go
+	redactionLock sync.Mutex
+	redaction     atomic.Pointer[redaction]
+
+	// the current log sampler, see sampling.go
+	sampler *samplingWriter
 }
 
+// LogCounterName is the name of the counter of log entries per level.
+//
+// Entries are counted before the per logger name level filter and log sampling, so the counter
+// includes entries that these dropped. Log sampling counts them again under LogDroppedCounterName.
 var LogCounterName = "logging_events_total"
 
 func (l *LoggingImpl) Setup() {
 	aulogging.RequestIdRetriever = requestid.GetReqID
 
-	l.Metrics = prometheus.NewCounterVec(
+	registerer := metrics.RegistererOrDefault(l.Registerer)
+
+	l.Metrics = metrics.Register(registerer, prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: LogCounterName,
-			Help: "How many log entries were written per level.",
+			Help: "How many log entries were logged per level, including those dropped by log sampling.",
+		},
+		[]string{"level"},
+	))
+
+	l.DroppedMetrics = metrics.Register(registerer, prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: LogDroppedCounterName,
+			Help: "How many log entries were dropped by log sampling per level.",
 		},
 		[]string{"level"},
-	)
-	prometheus.MustRegister(l.Metrics)
+	))
 
 	aulogging.LogEventCallback = l.loggingCallback
 
+	l.setupRedaction()
+
 	if l.Configuration.PlainLogging() {
 		aulogging.DefaultRequestIdValue = "00000000"
 		auzerolog.RequestIdFieldName = "trace.id"
 		// keep these two consistent, if they do not match, the default request id shows in the logs rather than the APM trace IDs
 		loggermiddleware.RequestIdFieldName = auzerolog.RequestIdFieldName
 		auzerolog.SetupPlaintextLogging()
-		zerolog.SetGlobalLevel(l.logLevel())
+		l.filterConsoleOutput(os.Stdout)
+		l.setupLevels()
 		aulogging.Logger.NoCtx().Info().Print("switching to developer friendly console log")
 	} else {
-		// stay with JSON logging and add ECS service.id field
+		// stay with JSON logging and add ECS fields
 		l.CustomSetupJsonLogging(l.Configuration.ApplicationName())
 	}
 
+	l.Configuration.Subscribe(l.configurationChanged)
+
 	l.Logger().NoCtx().Info().Print("logging is now available")
 }
 
+func (l *LoggingImpl) configurationChanged(ctx context.Context, events []repository.ConfigChangeEvent) {
+	for _, event := range events {
+		if event.Key == config.KeyLogLevel || event.Key == config.KeyLogLevels {
+			l.configuredLevelsChanged(ctx)
+			return
+		}
+	}
+}
+
 func (l *LoggingImpl) loggingCallback(_ context.Context, level string, _ string, _ error, _ map[string]string) {
 	l.Metrics.WithLabelValues(strings.ToLower(level)).Inc()
 }
@@ -65,9 +112,7 @@ func (l *LoggingImpl) CustomSetupJsonLogging(serviceName string) {
 	zerolog.MessageFieldName = "message" // correct by default
 	zerolog.ErrorFieldName = "error.message"
 
-	log.Logger = zerolog.New(os.Stdout).With().
-		Timestamp().
-		Str("service.name", serviceName).
+	log.Logger = l.withEcsFields(zerolog.New(l.levelFilter(l.redactingFilter(l.sampling(os.Stdout)))).With().Timestamp(), serviceName).
 		Logger()
 
 	zerolog.LevelTraceValue = "TRACE"
@@ -77,12 +122,55 @@ func (l *LoggingImpl) CustomSetupJsonLogging(serviceName string) {
 	zerolog.LevelErrorValue = "ERROR"
 	zerolog.LevelFatalValue = "FATAL"
 	zerolog.LevelPanicValue = "FATAL"
-	zerolog.SetGlobalLevel(l.logLevel())
+	l.setupLevels()
 
 	zerolog.TimestampFunc = func() time.Time { return time.Now().UTC() }
 	zerolog.TimeFieldFormat = "2006-01-02T15:04:05.000Z"
 
 	auzerolog.IsJson = true
+	aulogging.Logger = withEcsErrors(aulogging.Logger)
+}
+
+func (l *LoggingImpl) setupLevels() {
+	l.levelLock.Lock()
+	defer l.levelLock.Unlock()
+
+	l.runtimeLevels = make(map[string]*runtimeLevel)
+	l.applyLevels()
+}
+
+// filterConsoleOutput replaces the console writer that auzerolog.SetupPlaintextLogging has set up with
+// the same console writer behind the level filter, redaction and log sampling
+func (l *LoggingImpl) filterConsoleOutput(out io.Writer) {
+	log.Logger = log.Logger.Output(l.levelFilter(l.redactingFilter(l.sampling(consoleWriter(out)))))
+}
+
+// consoleWriter is configured like the one of auzerolog.SetupPlaintextLogging, which does not expose it
+func consoleWriter(out io.Writer) zerolog.ConsoleWriter {
+	return zerolog.ConsoleWriter{
+		Out:        out,
+		NoColor:    true,
+		TimeFormat: "15:04:05.000",
+		PartsOrder: []string{
+			zerolog.TimestampFieldName,
+			zerolog.LevelFieldName,
+			auzerolog.RequestIdFieldName,
+			zerolog.MessageFieldName,
+		},
+		FormatFieldName: func(_ interface{}) string {
+			return ""
+		},
+		FormatFieldValue: func(_ interface{}) string {
+			return ""
+		},
+		FormatCaller: func(value interface{}) string {
+			requestId, ok := value.(string)
+			if !ok {
+				return aulogging.DefaultRequestIdValue
+			}
+			return "[" + requestId + "]"
+		},
+	}
 }
 
 func (l *LoggingImpl) logLevel() zerolog.Level {
@@ -95,6 +183,8 @@ func (l *LoggingImpl) logLevel() zerolog.Level {
 }
 
 // alternative Setup function for testing that records log entries instead of writing them to console
+//
+// If you want to make assertions about log entries, see package loggingtest.
 func (l *LoggingImpl) SetupForTesting() {
 	auzerolog.SetupForTesting()
 }
@@ -0,0 +1,209 @@
+// Package loggingtest captures log entries in tests and lets you make assertions about them.
+//
+// Use New() and pass the context from Context() to the code under test. All logging through
+// aulogging.Logger.Ctx(ctx) then ends up in the recorder of your test, so tests can run with t.Parallel().
+//
+// Code that logs with NoCtx() writes to the global logger. Use CaptureGlobal() for that, but
+// then your test must not run in parallel with other tests that capture log entries.
+package loggingtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	// makes sure aulogging.Logger is backed by zerolog, even if the code under test does not set up logging
+	_ "github.com/StephanHCB/go-autumn-logging-zerolog"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Entry is a single recorded log entry.
+type Entry struct {
+	Level   zerolog.Level
+	Message string
+	// Fields contains all fields of the entry, including level and message
+	Fields map[string]interface{}
+}
+
+// Match describes the log entries an assertion is looking for. Empty parts match anything.
+type Match struct {
+	// Level is the exact level, e.g. "ERROR"
+	Level string
+	// MessageContains must be contained in the message
+	MessageContains string
+	// Fields must all be present with these values, compared in their string representation
+	Fields map[string]string
+}
+
+// Recorder records the log entries of a single test.
+type Recorder struct {
+	t testing.TB
+
+	lock    sync.Mutex
+	entries []Entry
+}
+
+// New creates a recorder for a test.
+func New(t testing.TB) *Recorder {
+	return &Recorder{
+		t:       t,
+		entries: make([]Entry, 0),
+	}
+}
+
+// CaptureGlobal creates a recorder that also receives everything logged to the global logger.
+//
+// The global logger is restored when the test ends. Do not use this in parallel tests.
+func CaptureGlobal(t testing.TB) *Recorder {
+	r := New(t)
+	original := log.Logger
+	log.Logger = r.Logger()
+	t.Cleanup(func() {
+		log.Logger = original
+	})
+	return r
+}
+
+// Logger returns a logger that writes to this recorder.
+//
+// Note that the zerolog global level still applies.
+func (r *Recorder) Logger() zerolog.Logger {
+	return zerolog.New(r).With().Timestamp().Logger()
+}
+
+// Context returns a child context that carries a logger writing to this recorder.
+func (r *Recorder) Context(ctx context.Context) context.Context {
+	logger := r.Logger()
+	return logger.WithContext(ctx)
+}
+
+// Write implements io.Writer, so the recorder can be used as the output of a zerolog logger.
+func (r *Recorder) Write(p []byte) (int, error) {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return 0, fmt.Errorf("failed to parse log entry: %s", err.Error())
+	}
+
+	entry := Entry{
+		Level:  zerolog.NoLevel,
+		Fields: fields,
+	}
+	if level, ok := fields[zerolog.LevelFieldName].(string); ok {
+		if parsed, err := zerolog.ParseLevel(level); err == nil {
+			entry.Level = parsed
+		}
+	}
+	if message, ok := fields[zerolog.MessageFieldName].(string); ok {
+		entry.Message = message
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.entries = append(r.entries, entry)
+	return len(p), nil
+}
+
+// Entries returns a copy of all entries recorded so far.
+func (r *Recorder) Entries() []Entry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]Entry{}, r.entries...)
+}
+
+// Find returns all recorded entries that match.
+func (r *Recorder) Find(match Match) []Entry {
+	result := make([]Entry, 0)
+	for _, entry := range r.Entries() {
+		if match.matches(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// AssertLogged checks that at least one matching entry was logged, and marks the test as failed if not.
+func (r *Recorder) AssertLogged(match Match) bool {
+	r.t.Helper()
+
+	if len(r.Find(match)) == 0 {
+		r.t.Errorf("expected a log entry matching %s, but there was none. Recorded entries:\n%s", match, r)
+		return false
+	}
+	return true
+}
+
+// RequireLogged is like AssertLogged, but stops the test.
+func (r *Recorder) RequireLogged(match Match) {
+	r.t.Helper()
+
+	if !r.AssertLogged(match) {
+		r.t.FailNow()
+	}
+}
+
+// AssertNotLogged checks that no matching entry was logged, and marks the test as failed if there was one.
+func (r *Recorder) AssertNotLogged(match Match) bool {
+	r.t.Helper()
+
+	if found := r.Find(match); len(found) > 0 {
+		r.t.Errorf("expected no log entry matching %s, but found %d. Recorded entries:\n%s", match, len(found), r)
+		return false
+	}
+	return true
+}
+
+// AssertNothingAtOrAbove checks that no entry with the given level or higher was logged, e.g. "WARN".
+func (r *Recorder) AssertNothingAtOrAbove(level string) bool {
+	r.t.Helper()
+
+	threshold, err := zerolog.ParseLevel(level)
+	if err != nil {
+		r.t.Errorf("invalid log level %s: %s", level, err.Error())
+		return false
+	}
+
+	for _, entry := range r.Entries() {
+		if entry.Level >= threshold && entry.Level < zerolog.NoLevel {
+			r.t.Errorf("expected no log entry at level %s or higher, but found one. Recorded entries:\n%s", level, r)
+			return false
+		}
+	}
+	return true
+}
+
+// String lists all recorded entries, one per line, for failure messages.
+func (r *Recorder) String() string {
+	builder := strings.Builder{}
+	for _, entry := range r.Entries() {
+		builder.WriteString(fmt.Sprintf("  %s %s %v\n", strings.ToUpper(entry.Level.String()), entry.Message, entry.Fields))
+	}
+	return builder.String()
+}
+
+func (m Match) matches(entry Entry) bool {
+	if m.Level != "" {
+		level, err := zerolog.ParseLevel(m.Level)
+		if err != nil || level != entry.Level {
+			return false
+		}
+	}
+	if !strings.Contains(entry.Message, m.MessageContains) {
+		return false
+	}
+	for name, expected := range m.Fields {
+		actual, ok := entry.Fields[name]
+		if !ok || fmt.Sprintf("%v", actual) != expected {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Match) String() string {
+	return fmt.Sprintf("{level: '%s', message containing: '%s', fields: %v}", m.Level, m.MessageContains, m.Fields)
+}
@@ -0,0 +1,69 @@
+package loggingtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRecorder_Parallel(t *testing.T) {
+	docs.Description("each test only sees its own log entries when using the context logger")
+
+	for i := 0; i < 5; i++ {
+		i := i
+		t.Run(fmt.Sprintf("parallel-%d", i), func(t *testing.T) {
+			t.Parallel()
+
+			cut := New(t)
+			ctx := cut.Context(context.Background())
+
+			aulogging.Logger.Ctx(ctx).Info().With("worker", fmt.Sprintf("%d", i)).Printf("working on %d", i)
+			aulogging.Logger.Ctx(ctx).Error().WithErr(errors.New("disk full")).Print("failed to save")
+
+			require.Len(t, cut.Entries(), 2)
+			cut.RequireLogged(Match{Level: "INFO", MessageContains: fmt.Sprintf("on %d", i), Fields: map[string]string{"worker": fmt.Sprintf("%d", i)}})
+			cut.RequireLogged(Match{Level: "ERROR", MessageContains: "save"})
+			cut.AssertNotLogged(Match{Fields: map[string]string{"worker": fmt.Sprintf("%d", i+1)}})
+		})
+	}
+}
+
+func TestRecorder_FailedAssertions(t *testing.T) {
+	docs.Description("assertions fail the test if log entries do not match")
+
+	mock := &tstMockT{TB: t}
+	cut := New(mock)
+	ctx := cut.Context(context.Background())
+
+	aulogging.Logger.Ctx(ctx).Warn().Print("careful")
+
+	require.False(t, cut.AssertLogged(Match{Level: "ERROR"}))
+	require.False(t, cut.AssertNothingAtOrAbove("WARN"))
+	require.True(t, cut.AssertNothingAtOrAbove("ERROR"))
+	require.Equal(t, 2, mock.errors)
+}
+
+func TestRecorder_CaptureGlobal(t *testing.T) {
+	docs.Description("entries written to the global logger can be captured, too")
+
+	cut := CaptureGlobal(t)
+
+	aulogging.Logger.NoCtx().Info().Print("global entry")
+
+	cut.RequireLogged(Match{Level: "INFO", MessageContains: "global"})
+}
+
+// --- helpers ---
+
+type tstMockT struct {
+	testing.TB
+	errors int
+}
+
+func (m *tstMockT) Errorf(_ string, _ ...interface{}) {
+	m.errors++
+}
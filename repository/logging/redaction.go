@@ -0,0 +1,254 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/rs/zerolog"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// RedactionMask replaces every redacted value in log entries
+var RedactionMask = "***"
+
+// MinimumRedactedValueLength protects against masking unrelated text with very short values passed to Redact()
+var MinimumRedactedValueLength = 4
+
+// redaction is the immutable set of rules that are applied to each log entry
+type redaction struct {
+	fields   map[string]bool
+	patterns []*regexp.Regexp
+	values   []string
+}
+
+func (l *LoggingImpl) setupRedaction() {
+	fields := make(map[string]bool)
+	for _, name := range l.Configuration.LogRedactFields() {
+		fields[strings.ToLower(name)] = true
+	}
+
+	l.redactionLock.Lock()
+	defer l.redactionLock.Unlock()
+
+	current := l.currentRedaction()
+	l.redaction.Store(&redaction{
+		fields:   fields,
+		patterns: l.Configuration.LogRedactPatterns(),
+		values:   current.values,
+	})
+}
+
+func (l *LoggingImpl) Redact(value string) {
+	if len(value) < MinimumRedactedValueLength {
+		return
+	}
+
+	l.redactionLock.Lock()
+	defer l.redactionLock.Unlock()
+
+	current := l.currentRedaction()
+	for _, known := range current.values {
+		if known == value {
+			return
+		}
+	}
+
+	values := append(append([]string{}, current.values...), value)
+	// longest first, so a value that contains another value is masked as a whole
+	for i := len(values) - 1; i > 0 && len(values[i]) > len(values[i-1]); i-- {
+		values[i], values[i-1] = values[i-1], values[i]
+	}
+
+	l.redaction.Store(&redaction{
+		fields:   current.fields,
+		patterns: current.patterns,
+		values:   values,
+	})
+}
+
+func (l *LoggingImpl) currentRedaction() *redaction {
+	if current := l.redaction.Load(); current != nil {
+		return current
+	}
+	return &redaction{}
+}
+
+// redactingWriter masks sensitive values in json encoded log entries before passing them on.
+//
+// In plain mode it is placed in front of the console writer, which also receives json.
+type redactingWriter struct {
+	next io.Writer
+	impl *LoggingImpl
+}
+
+func (l *LoggingImpl) redactingFilter(next io.Writer) io.Writer {
+	return &redactingWriter{
+		next: next,
+		impl: l,
+	}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.next.Write(w.impl.currentRedaction().apply(p)); err != nil {
+		return 0, err
+	}
+	// report the original length, or zerolog complains about a short write
+	return len(p), nil
+}
+
+func (w *redactingWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if _, err := writeLevel(w.next, level, w.impl.currentRedaction().apply(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *redaction) isEmpty() bool {
+	return len(r.fields) == 0 && len(r.patterns) == 0 && len(r.values) == 0
+}
+
+// apply scans a json encoded log entry and masks values of redacted fields, and matches in all other strings.
+//
+// Only string contents are changed, so the result stays valid json. Input that cannot be scanned is passed on unchanged.
+func (r *redaction) apply(p []byte) []byte {
+	if r.isEmpty() {
+		return p
+	}
+
+	out := make([]byte, 0, len(p))
+	for i := 0; i < len(p); {
+		if p[i] != '"' {
+			out = append(out, p[i])
+			i++
+			continue
+		}
+
+		end := stringEnd(p, i)
+		if end < 0 {
+			return p
+		}
+		token := p[i : end+1]
+
+		if valueStart, isKey := keyValueStart(p, end+1); isKey {
+			out = append(out, token...)
+			out = append(out, p[end+1:valueStart]...)
+			if r.fields[strings.ToLower(string(token[1:len(token)-1]))] {
+				valueEnd := valueEnd(p, valueStart)
+				if valueEnd < 0 {
+					return p
+				}
+				out = append(out, '"')
+				out = append(out, RedactionMask...)
+				out = append(out, '"')
+				i = valueEnd
+			} else {
+				i = valueStart
+			}
+			continue
+		}
+
+		out = append(out, r.redactString(token)...)
+		i = end + 1
+	}
+	return out
+}
+
+// redactString masks matches in a quoted json string, returning it unchanged if nothing matches
+func (r *redaction) redactString(token []byte) []byte {
+	value := string(token[1 : len(token)-1])
+	if bytes.IndexByte(token, '\\') >= 0 {
+		if err := json.Unmarshal(token, &value); err != nil {
+			return token
+		}
+	}
+
+	redacted := value
+	for _, secret := range r.values {
+		redacted = strings.ReplaceAll(redacted, secret, RedactionMask)
+	}
+	for _, pattern := range r.patterns {
+		redacted = pattern.ReplaceAllLiteralString(redacted, RedactionMask)
+	}
+	if redacted == value {
+		return token
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(redacted); err != nil {
+		return token
+	}
+	return bytes.TrimRight(buffer.Bytes(), "\n")
+}
+
+// stringEnd returns the index of the closing quote of the json string starting at start, or -1
+func stringEnd(p []byte, start int) int {
+	for i := start + 1; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// keyValueStart checks if a string ending before pos is an object key, and returns where its value starts
+func keyValueStart(p []byte, pos int) (int, bool) {
+	i := skipWhitespace(p, pos)
+	if i >= len(p) || p[i] != ':' {
+		return pos, false
+	}
+	return skipWhitespace(p, i+1), true
+}
+
+// valueEnd returns the index after the json value starting at start, or -1
+func valueEnd(p []byte, start int) int {
+	if start >= len(p) {
+		return -1
+	}
+	if p[start] == '"' {
+		end := stringEnd(p, start)
+		if end < 0 {
+			return -1
+		}
+		return end + 1
+	}
+
+	depth := 0
+	for i := start; i < len(p); i++ {
+		switch p[i] {
+		case '"':
+			end := stringEnd(p, i)
+			if end < 0 {
+				return -1
+			}
+			i = end
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return i
+			}
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case ',':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(p)
+}
+
+func skipWhitespace(p []byte, pos int) int {
+	for pos < len(p) && (p[pos] == ' ' || p[pos] == '\t' || p[pos] == '\n' || p[pos] == '\r') {
+		pos++
+	}
+	return pos
+}
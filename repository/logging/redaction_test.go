@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRedaction_FieldsAndPatterns(t *testing.T) {
+	docs.Description("configured fields and patterns are masked in log entries")
+
+	cut, buffer, _ := tstSetupCutWithLevels(t, "INFO", "")
+	cut.setupRedaction()
+	logger := zerolog.New(cut.redactingFilter(buffer))
+
+	logger.Info().
+		Str("Authorization", "Basic dXNlcjpwYXNz").
+		Interface("headers", map[string]string{"Cookie": "session=abc", "Accept": "text/plain"}).
+		Str("detail", "contact jane.doe@example.com").
+		Msg("received \"Bearer abc.def-ghi\" from client")
+
+	actual := buffer.String()
+	require.Contains(t, actual, `"Authorization":"***"`)
+	require.Contains(t, actual, `"Cookie":"***"`)
+	require.Contains(t, actual, `"Accept":"text/plain"`)
+	require.Contains(t, actual, `"detail":"contact ***"`)
+	require.Contains(t, actual, `"message":"received \"***\" from client"`)
+	require.True(t, json.Valid(bytes.TrimSpace(buffer.Bytes())))
+}
+
+func TestRedaction_Values(t *testing.T) {
+	docs.Description("values passed to Redact are masked everywhere, values that are too short are ignored")
+
+	t.Setenv(config.KeyLogRedactFields, "-")
+	t.Setenv(config.KeyLogRedactPatterns, "[]")
+	cut, buffer, _ := tstSetupCutWithLevels(t, "INFO", "")
+	cut.setupRedaction()
+	cut.Redact("s3cr3t-db-password")
+	cut.Redact("abc")
+	logger := zerolog.New(cut.redactingFilter(buffer))
+
+	logger.Info().Str("password", "abc").Msg("connecting with s3cr3t-db-password")
+
+	require.Contains(t, buffer.String(), `"password":"abc"`)
+	require.Contains(t, buffer.String(), `"message":"connecting with ***"`)
+}
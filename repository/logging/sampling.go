@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var LogDroppedCounterName = "logging_events_dropped_total"
+
+// SamplingLoggerName is the logger name used for the summary of dropped log entries
+var SamplingLoggerName = "logging.sampling"
+
+// sampling puts a new log sampler in front of out if configured, and stops the previous one
+func (l *LoggingImpl) sampling(out io.Writer) io.Writer {
+	if l.sampler != nil {
+		l.sampler.stop()
+		l.sampler = nil
+	}
+	if l.Configuration.LogSamplingBurst() == 0 {
+		return out
+	}
+
+	l.sampler = newSamplingWriter(out, l.Configuration.LogSamplingBurst(), l.Configuration.LogSamplingThereafter(), l.DroppedMetrics)
+	l.sampler.startPeriods(l, time.Duration(l.Configuration.LogSamplingPeriodSeconds())*time.Second)
+	return l.sampler
+}
+
+// samplingWriter limits the number of log entries with the same level and message per period.
+//
+// The first burst entries are written, after that only every thereafter-th entry until the period ends.
+type samplingWriter struct {
+	next       io.Writer
+	burst      uint
+	thereafter uint
+	dropped    *prometheus.CounterVec
+
+	lock          sync.Mutex
+	counts        map[string]uint
+	droppedCounts map[zerolog.Level]uint
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newSamplingWriter(next io.Writer, burst uint, thereafter uint, dropped *prometheus.CounterVec) *samplingWriter {
+	return &samplingWriter{
+		next:          next,
+		burst:         burst,
+		thereafter:    thereafter,
+		dropped:       dropped,
+		counts:        make(map[string]uint),
+		droppedCounts: make(map[zerolog.Level]uint),
+		done:          make(chan struct{}),
+	}
+}
+
+func (w *samplingWriter) Write(p []byte) (int, error) {
+	return w.next.Write(p)
+}
+
+func (w *samplingWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	message, _ := jsonStringField(p, zerolog.MessageFieldName)
+	key := level.String() + " " + message
+
+	w.lock.Lock()
+	w.counts[key]++
+	count := w.counts[key]
+	write := count <= w.burst || (w.thereafter > 0 && (count-w.burst)%w.thereafter == 0)
+	if !write {
+		w.droppedCounts[level]++
+	}
+	w.lock.Unlock()
+
+	if !write {
+		if w.dropped != nil {
+			w.dropped.WithLabelValues(strings.ToLower(level.String())).Inc()
+		}
+		// pretend to have written the entry
+		return len(p), nil
+	}
+	return w.next.Write(p)
+}
+
+// startPeriods resets the counts after each period and logs a summary of dropped log entries, until stop is called
+func (w *samplingWriter) startPeriods(l *LoggingImpl, period time.Duration) {
+	if period <= 0 {
+		// configuration is not validated yet during logging setup
+		period = 10 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				w.endPeriod(l, period)
+			}
+		}
+	}()
+}
+
+// stop ends the periods, so a sampler that is replaced does not keep running
+func (w *samplingWriter) stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *samplingWriter) endPeriod(l *LoggingImpl, period time.Duration) {
+	w.lock.Lock()
+	droppedCounts := w.droppedCounts
+	w.counts = make(map[string]uint)
+	w.droppedCounts = make(map[zerolog.Level]uint)
+	w.lock.Unlock()
+
+	if summary, total := droppedSummary(droppedCounts); total > 0 {
+		l.Logger().NoCtx().Warn().
+			With(LoggerNameFieldName, SamplingLoggerName).
+			Printf("log sampling dropped %d log entries in the last %s (%s)", total, period.String(), summary)
+	}
+}
+
+func droppedSummary(droppedCounts map[zerolog.Level]uint) (string, uint) {
+	levels := make([]zerolog.Level, 0, len(droppedCounts))
+	for level := range droppedCounts {
+		levels = append(levels, level)
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i] > levels[j]
+	})
+
+	total := uint(0)
+	parts := make([]string, 0, len(levels))
+	for _, level := range levels {
+		total += droppedCounts[level]
+		parts = append(parts, fmt.Sprintf("%s: %d", strings.ToUpper(level.String()), droppedCounts[level]))
+	}
+	return strings.Join(parts, ", "), total
+}
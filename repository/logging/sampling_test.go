@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"bytes"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestSampling_BurstThenSample(t *testing.T) {
+	docs.Description("log sampling writes a burst of identical entries, then only every n-th entry")
+
+	buffer := new(bytes.Buffer)
+	dropped := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_dropped"}, []string{"level"})
+	cut := newSamplingWriter(buffer, 3, 5, dropped)
+	logger := zerolog.New(cut)
+
+	for i := 0; i < 20; i++ {
+		logger.Error().Msg("downstream \"unavailable\"")
+	}
+	logger.Error().Msg("another message")
+	logger.Warn().Msg("downstream \"unavailable\"")
+
+	// 3 burst + entries 8, 13, 18
+	expected := make([]string, 0)
+	for i := 0; i < 6; i++ {
+		expected = append(expected, `{"level":"error","message":"downstream \"unavailable\""}`)
+	}
+	expected = append(expected, `{"level":"error","message":"another message"}`, `{"level":"warn","message":"downstream \"unavailable\""}`)
+	require.Equal(t, expected, strings.Split(strings.TrimSpace(buffer.String()), "\n"))
+	require.Equal(t, float64(14), testutil.ToFloat64(dropped.WithLabelValues("error")))
+
+	summary, total := droppedSummary(cut.droppedCounts)
+	require.Equal(t, uint(14), total)
+	require.Equal(t, "ERROR: 14", summary)
+}
+
+func TestSampling_Stop(t *testing.T) {
+	docs.Description("setting up log sampling again stops the periods of the previous log sampler")
+
+	t.Setenv(config.KeyLogSamplingBurst, "5")
+	cut, _, _ := tstSetupCutWithLevels(t, "INFO", "")
+
+	buffer := new(bytes.Buffer)
+	require.Same(t, cut.sampler, cut.sampling(buffer))
+	first := cut.sampler
+	require.Same(t, cut.sampler, cut.sampling(buffer))
+	require.NotSame(t, first, cut.sampler)
+
+	select {
+	case <-first.done:
+	default:
+		require.Fail(t, "previous sampler was not stopped")
+	}
+	cut.sampler.stop()
+}
+
+func TestSampling_FieldExtraction(t *testing.T) {
+	docs.Description("fields are extracted from json log entries including escaped quotes")
+
+	value, ok := jsonStringField([]byte(`{"log.level":"INFO","message":"say \"hi\"","log.logger":"vault"}`), "message")
+	require.True(t, ok)
+	require.Equal(t, `say \"hi\"`, value)
+
+	name, ok := loggerName([]byte(`{"log.level":"INFO","message":"say \"hi\"","log.logger":"vault"}`))
+	require.True(t, ok)
+	require.Equal(t, "vault", name)
+
+	_, ok = loggerName([]byte(`{"log.level":"INFO","message":"hi"}`))
+	require.False(t, ok)
+}
+
+func TestSampling_BehindLevelFilter(t *testing.T) {
+	docs.Description("log sampling still sees the level of log entries behind the level filter")
+
+	cut := &LoggingImpl{}
+	cut.thresholds.Store(&levelThresholds{root: zerolog.InfoLevel, named: map[string]zerolog.Level{"vault": zerolog.DebugLevel}})
+
+	buffer := new(bytes.Buffer)
+	logger := zerolog.New(cut.levelFilter(newSamplingWriter(buffer, 1, 0, nil)))
+	logger.Warn().Msg("repeated")
+	logger.Warn().Msg("repeated")
+
+	require.Equal(t, 1, strings.Count(buffer.String(), "repeated"))
+}
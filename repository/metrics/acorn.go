@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	auacornapi "github.com/StephanHCB/go-autumn-acorn-registry/api"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"time"
+)
+
+// --- implementing Acorn ---
+
+func New() auacornapi.Acorn {
+	return &MetricsImpl{
+		StartTime: time.Now(),
+	}
+}
+
+// NewNoAcorn constructs the component, but does not set it up.
+//
+// You still need to call Setup() after the configuration was set up.
+func NewNoAcorn(configuration repository.Configuration, logging repository.Logging) repository.Metrics {
+	return &MetricsImpl{
+		Configuration: configuration,
+		Logging:       logging,
+		StartTime:     time.Now(),
+	}
+}
+
+func (m *MetricsImpl) IsMetrics() bool {
+	return true
+}
+
+func (m *MetricsImpl) AcornName() string {
+	return repository.MetricsAcornName
+}
+
+func (m *MetricsImpl) AssembleAcorn(registry auacornapi.AcornRegistry) error {
+	m.Configuration = registry.GetAcornByName(repository.ConfigurationAcornName).(repository.Configuration)
+	m.Logging = registry.GetAcornByName(repository.LoggingAcornName).(repository.Logging)
+
+	return nil
+}
+
+func (m *MetricsImpl) SetupAcorn(registry auacornapi.AcornRegistry) error {
+	if err := registry.SetupAfter(m.Configuration.(auacornapi.Acorn)); err != nil {
+		return err
+	}
+
+	return m.Setup()
+}
+
+func (m *MetricsImpl) TeardownAcorn(registry auacornapi.AcornRegistry) error {
+	return nil
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/StephanHCB/go-backend-service-common/repository/buildinfo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"time"
+)
+
+var (
+	ApplicationInfoName        = "application_info"
+	ApplicationStartedTimeName = "application_started_time_seconds"
+)
+
+type MetricsImpl struct {
+	Configuration repository.Configuration
+	Logging       repository.Logging
+	// Registerer is used for all application metrics, leave nil to use metrics.Registerer
+	Registerer prometheus.Registerer
+
+	StartTime time.Time
+
+	ApplicationInfo *prometheus.GaugeVec
+	StartedTime     prometheus.Gauge
+}
+
+func (m *MetricsImpl) Setup() error {
+	registerer := RegistererOrDefault(m.Registerer)
+
+	m.registerRuntimeCollector(registerer, "process", collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	m.registerRuntimeCollector(registerer, "Go runtime", collectors.NewGoCollector())
+
+	m.ApplicationInfo = m.registerApplicationInfo(registerer, m.Configuration.Environment())
+
+	m.StartedTime = Register(registerer, prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: ApplicationStartedTimeName,
+			Help: "Time taken to start the application.",
+		},
+	))
+	// the startup time so far, Started replaces it once the application is ready
+	m.StartedTime.Set(time.Since(m.StartTime).Seconds())
+
+	return nil
+}
+
+// registerApplicationInfo leaves out the environment label if the registerer already adds it as a const label,
+// e.g. with ApplicationLabels, because prometheus does not allow the same label twice.
+func (m *MetricsImpl) registerApplicationInfo(registerer prometheus.Registerer, environment string) *prometheus.GaugeVec {
+	labels := []string{"version", "revision", "go_version", "environment"}
+	values := []string{buildinfo.ServiceVersion(), buildinfo.Revision(), buildinfo.GoVersion(), environment}
+
+	info := newApplicationInfo(labels)
+	if err := registerer.Register(info); err != nil {
+		alreadyRegistered := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &alreadyRegistered) {
+			info = Register(registerer, info)
+		} else {
+			m.Logging.Logger().NoCtx().Info().Printf("%s cannot be registered with an environment label, leaving it out: %s", ApplicationInfoName, err.Error())
+			labels, values = labels[:3], values[:3]
+			info = Register(registerer, newApplicationInfo(labels))
+		}
+	}
+	info.WithLabelValues(values...).Set(1)
+	return info
+}
+
+func newApplicationInfo(labels []string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: ApplicationInfoName,
+			Help: "Information about the running application, always 1.",
+		},
+		labels,
+	)
+}
+
+// registerRuntimeCollector skips the collector if the registry already has the same metrics.
+//
+// The global prometheus registry already has them. They are skipped then, even if the registerer adds const labels,
+// because prometheus does not allow the same metric with different label names.
+func (m *MetricsImpl) registerRuntimeCollector(registerer prometheus.Registerer, name string, collector prometheus.Collector) {
+	if err := registerer.Register(collector); err != nil {
+		alreadyRegistered := prometheus.AlreadyRegisteredError{}
+		if !errors.As(err, &alreadyRegistered) {
+			m.Logging.Logger().NoCtx().Info().Printf("%s metrics are already registered, skipping them: %s", name, err.Error())
+		}
+	}
+}
+
+// Started records how long it took to start the application, see repository.Metrics.
+//
+// Setup already records the time until the metrics are set up, but only your main knows when the service is ready.
+func (m *MetricsImpl) Started(ctx context.Context) {
+	duration := time.Since(m.StartTime)
+	if m.StartedTime != nil {
+		m.StartedTime.Set(duration.Seconds())
+	}
+	m.Logging.Logger().Ctx(ctx).Info().Printf("application started in %d ms", duration.Milliseconds())
+}
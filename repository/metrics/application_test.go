@@ -0,0 +1,96 @@
+package metrics_test
+
+import (
+	"context"
+	auzerolog "github.com/StephanHCB/go-autumn-logging-zerolog"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/repository/buildinfo"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/StephanHCB/go-backend-service-common/repository/logging"
+	"github.com/StephanHCB/go-backend-service-common/repository/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplication_Metrics(t *testing.T) {
+	docs.Description("application info, runtime and startup metrics are registered")
+
+	registry := prometheus.NewRegistry()
+	buildinfo.Version = "1.2.3"
+	defer func() { buildinfo.Version = "" }()
+
+	cut := tstMetrics(t)
+	cut.Registerer = registry
+	cut.StartTime = time.Now().Add(-2 * time.Second)
+	require.Nil(t, cut.Setup())
+	require.InDelta(t, 2.0, testutil.ToFloat64(cut.StartedTime), 0.5)
+
+	cut.StartTime = cut.StartTime.Add(-time.Second)
+	cut.Started(context.Background())
+
+	expected := `
+# HELP application_info Information about the running application, always 1.
+# TYPE application_info gauge
+application_info{environment="test",go_version="` + runtime.Version() + `",revision="` + buildinfo.Revision() + `",version="1.2.3"} 1
+`
+	require.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), metrics.ApplicationInfoName))
+	require.InDelta(t, 3.0, testutil.ToFloat64(cut.StartedTime), 0.5)
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	names := make([]string, 0)
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	require.Contains(t, names, "go_goroutines")
+	require.Contains(t, names, "process_open_fds")
+}
+
+func TestApplication_GlobalRegistry(t *testing.T) {
+	docs.Description("application metrics can be set up on the global registry, which already has the runtime metrics")
+
+	cut := tstMetrics(t)
+	require.NotPanics(t, func() {
+		require.Nil(t, cut.Setup())
+	})
+}
+
+func TestApplication_ConstLabels(t *testing.T) {
+	docs.Description("application metrics can be set up with the documented application const labels")
+
+	// like the global prometheus registry
+	registry := prometheus.NewRegistry()
+	require.Nil(t, registry.Register(collectors.NewGoCollector()))
+	require.Nil(t, registry.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})))
+
+	cut := tstMetrics(t)
+	cut.Registerer = metrics.WithConstLabels(registry, metrics.ApplicationLabels(cut.Configuration))
+	require.NotPanics(t, func() {
+		require.Nil(t, cut.Setup())
+	})
+
+	expected := `
+# HELP application_info Information about the running application, always 1.
+# TYPE application_info gauge
+application_info{application="` + cut.Configuration.ApplicationName() + `",environment="test",go_version="` + runtime.Version() + `",revision="` + buildinfo.Revision() + `",version="` + buildinfo.ServiceVersion() + `"} 1
+`
+	require.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), metrics.ApplicationInfoName))
+	require.Contains(t, auzerolog.RecordedLogForTesting.String(), "application_info cannot be registered with an environment label, leaving it out")
+}
+
+// --- helpers ---
+
+func tstMetrics(t *testing.T) *metrics.MetricsImpl {
+	t.Setenv(config.KeyEnvironment, "test")
+	configuration := config.NewNoAcorn(nil, nil)
+	logger := logging.NewNoAcorn(configuration)
+	logger.(*logging.LoggingImpl).SetupForTesting()
+	require.Nil(t, configuration.Assemble(logger))
+	return metrics.NewNoAcorn(configuration, logger).(*metrics.MetricsImpl)
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.elastic.co/apm/v2"
+	"net/http"
+)
+
+// TraceIdExemplarLabel is the exemplar label that carries the APM trace.id.
+//
+// Prometheus label names cannot contain dots, so configure the Grafana exemplar link on trace_id.
+var TraceIdExemplarLabel = "trace_id"
+
+// TraceExemplar returns the exemplar labels for the APM transaction in the context, or nil if there is none.
+func TraceExemplar(ctx context.Context) prometheus.Labels {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return nil
+	}
+	traceId := tx.TraceContext().Trace
+	if traceId.Validate() != nil {
+		return nil
+	}
+	return prometheus.Labels{TraceIdExemplarLabel: traceId.String()}
+}
+
+// ObserveWithTrace observes a value, attaching the APM trace.id from the context as an exemplar if there is one.
+//
+// Exemplars are only exposed for histograms and counters, and only in the OpenMetrics format, see Handler.
+func ObserveWithTrace(ctx context.Context, observer prometheus.Observer, value float64) {
+	if exemplar := TraceExemplar(ctx); exemplar != nil {
+		if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+			exemplarObserver.ObserveWithExemplar(value, exemplar)
+			return
+		}
+	}
+	observer.Observe(value)
+}
+
+// Handler serves the metrics of the registry behind Registerer, see HandlerFor.
+//
+// Registerers returned by WithConstLabels serve the registry they wrap. Panics if there is no registry
+// to gather from, use HandlerFor then.
+func Handler() http.Handler {
+	gatherer, ok := gathererOf(Registerer)
+	if !ok {
+		panic("cannot find the registry behind metrics.Registerer, use metrics.HandlerFor")
+	}
+	return HandlerFor(gatherer)
+}
+
+func gathererOf(registerer prometheus.Registerer) (prometheus.Gatherer, bool) {
+	for {
+		if gatherer, ok := registerer.(prometheus.Gatherer); ok {
+			return gatherer, true
+		}
+		labelled, ok := registerer.(*labelledRegisterer)
+		if !ok {
+			return nil, false
+		}
+		registerer = labelled.wrapped
+	}
+}
+
+// HandlerFor serves the metrics of the given gatherer.
+//
+// Unlike promhttp.Handler, this negotiates the OpenMetrics format, which is needed to expose exemplars.
+// Prometheus asks for it if exemplar storage is enabled.
+func HandlerFor(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+}
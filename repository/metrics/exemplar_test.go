@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestObserveWithTrace(t *testing.T) {
+	docs.Description("histogram observations carry the APM trace.id as an exemplar, which is served in the OpenMetrics format")
+
+	registry := prometheus.NewRegistry()
+	histogram := Register(registry, tstHistogram())
+
+	tx := apmtest.DiscardTracer.StartTransaction("GET /things", "request")
+	defer tx.End()
+	traceId := tx.TraceContext().Trace.String()
+
+	ObserveWithTrace(apm.ContextWithTransaction(context.Background(), tx), histogram, 0.3)
+	ObserveWithTrace(context.Background(), histogram, 0.7)
+
+	openMetrics := tstScrape(t, registry, string(expfmt.NewFormat(expfmt.TypeOpenMetrics)))
+	require.Contains(t, openMetrics, `test_seconds_bucket{le="0.5"} 1 # {trace_id="`+traceId+`"} 0.3`)
+	require.Contains(t, openMetrics, `test_seconds_count 2`)
+
+	text := tstScrape(t, registry, "text/plain")
+	require.Contains(t, text, `test_seconds_bucket{le="0.5"} 1`)
+	require.NotContains(t, text, traceId)
+}
+
+func TestTraceExemplar_NoTransaction(t *testing.T) {
+	docs.Description("without an APM transaction in the context there is no exemplar")
+
+	require.Nil(t, TraceExemplar(context.Background()))
+}
+
+func TestHandler_Registerer(t *testing.T) {
+	docs.Description("the metrics handler serves the registry behind metrics.Registerer, also with const labels")
+
+	previous := Registerer
+	defer func() { Registerer = previous }()
+	registry := prometheus.NewRegistry()
+	Registerer = WithConstLabels(registry, prometheus.Labels{"application": "room-service"})
+	Register(Registerer, tstHistogram()).Observe(0.3)
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	response := httptest.NewRecorder()
+	Handler().ServeHTTP(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), `test_seconds_count{application="room-service"} 1`)
+
+	Registerer = prometheus.WrapRegistererWithPrefix("test_", registry)
+	require.Panics(t, func() {
+		Handler()
+	})
+}
+
+// --- helpers ---
+
+func tstHistogram() prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "test", Buckets: []float64{0.5, 1}})
+}
+
+func tstScrape(t *testing.T, registry *prometheus.Registry, accept string) string {
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Accept", accept)
+	response := httptest.NewRecorder()
+	HandlerFor(registry).ServeHTTP(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	body, err := io.ReadAll(response.Body)
+	require.Nil(t, err)
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,67 @@
+// Package metrics contains the common plumbing for all components of this library that produce prometheus metrics.
+package metrics
+
+import (
+	"errors"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registerer is used by all components of this library that produce metrics, unless you give them one explicitly.
+//
+// Must be set before setting up the components. Defaults to the global prometheus registry.
+var Registerer prometheus.Registerer = prometheus.DefaultRegisterer
+
+// RegistererOrDefault returns registerer, or Registerer if it is nil.
+func RegistererOrDefault(registerer prometheus.Registerer) prometheus.Registerer {
+	if registerer == nil {
+		return Registerer
+	}
+	return registerer
+}
+
+// Register registers a collector, or returns the equal collector that was registered before.
+//
+// This is what allows setting up components such as the middleware stack more than once. It still panics
+// if a different collector with the same name was registered, e.g. one with different labels.
+func Register[T prometheus.Collector](registerer prometheus.Registerer, collector T) T {
+	if err := registerer.Register(collector); err != nil {
+		alreadyRegistered := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &alreadyRegistered) {
+			if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return collector
+}
+
+// WithConstLabels returns a registerer that adds constant labels to all metrics registered through it.
+//
+// Note that prometheus does not allow metrics with the same name but different label names, so use the
+// same constant labels for all components.
+func WithConstLabels(registerer prometheus.Registerer, labels prometheus.Labels) prometheus.Registerer {
+	return &labelledRegisterer{
+		Registerer: prometheus.WrapRegistererWith(labels, registerer),
+		wrapped:    registerer,
+	}
+}
+
+// labelledRegisterer remembers the registerer it wraps, so Handler can find the registry to gather from
+type labelledRegisterer struct {
+	prometheus.Registerer
+	wrapped prometheus.Registerer
+}
+
+// ApplicationLabels are the constant labels "application" and "environment" taken from the configuration.
+//
+// Typical use, before setting up the other components:
+//
+//	metrics.Registerer = metrics.WithConstLabels(prometheus.DefaultRegisterer, metrics.ApplicationLabels(configuration))
+func ApplicationLabels(configuration repository.Configuration) prometheus.Labels {
+	return prometheus.Labels{
+		"application": configuration.ApplicationName(),
+		"environment": configuration.Environment(),
+	}
+}
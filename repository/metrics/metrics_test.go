@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRegister_Twice(t *testing.T) {
+	docs.Description("registering an equal collector twice returns the first one instead of panicking")
+
+	registry := prometheus.NewRegistry()
+	registerer := WithConstLabels(registry, prometheus.Labels{"application": "room-service", "environment": "test"})
+
+	first := Register(registerer, tstCounter())
+	second := Register(registerer, tstCounter())
+	second.WithLabelValues("a").Inc()
+
+	require.Same(t, first, second)
+	require.Equal(t, float64(1), testutil.ToFloat64(first.WithLabelValues("a")))
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	require.Len(t, families, 1)
+	require.Len(t, families[0].Metric[0].Label, 3)
+}
+
+func TestRegister_Conflict(t *testing.T) {
+	docs.Description("registering a different collector with the same name still panics")
+
+	registry := prometheus.NewRegistry()
+	Register(registry, tstCounter())
+
+	require.Panics(t, func() {
+		Register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "test"}, []string{"other"}))
+	})
+}
+
+// --- helpers ---
+
+func tstCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "test"}, []string{"kind"})
+}
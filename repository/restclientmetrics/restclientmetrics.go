@@ -0,0 +1,151 @@
+// Package restclientmetrics instruments go-autumn-restclient http clients with aurestclientprometheus,
+// but lets you choose the prometheus registerer.
+//
+// The metrics are the ones defined by aurestclientprometheus. It registers them on the global prometheus
+// registry the first time it is set up, so this package catches them while it sets them up and registers
+// them on the registerer you choose instead. Only histogram mode adds a metric of its own.
+package restclientmetrics
+
+import (
+	"context"
+	"fmt"
+	aurestclientprometheus "github.com/StephanHCB/go-autumn-restclient-prometheus"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
+	"github.com/StephanHCB/go-backend-service-common/repository/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HistogramName is used instead of http_client_requests_seconds_count and _sum in histogram mode
+var HistogramName = "http_client_requests_seconds"
+
+// replacedByHistogram are the aurestclientprometheus metrics that are not registered in histogram mode,
+// because the histogram series http_client_requests_seconds_count and _sum would collide with them
+var replacedByHistogram = []string{"http_client_requests_seconds_count", "http_client_requests_seconds_sum"}
+
+type Options struct {
+	// Histogram switches the request count and latency from a counter and a summary to a histogram
+	// named HistogramName, whose observations carry the APM trace.id as an exemplar.
+	//
+	// Do not mix with aurestclientprometheus on the same registry, its metrics would collide with the histogram.
+	Histogram bool
+	// Buckets are the upper bounds of the histogram buckets in seconds, default prometheus.DefBuckets
+	Buckets []float64
+}
+
+var (
+	upstreamOnce       sync.Once
+	upstreamCollectors []prometheus.Collector
+)
+
+// InstrumentHttpClient adds the aurestclientprometheus metrics to an http client, registered on the given
+// registerer, or on metrics.Registerer if nil.
+//
+// The metrics are shared by all instrumented clients, whichever registerer they were instrumented for.
+//
+// The first call must happen before anything else sets up aurestclientprometheus, e.g. by calling its
+// InstrumentHttpClient directly. Otherwise the metrics stay on the global prometheus registry.
+func InstrumentHttpClient(client aurestclientapi.Client, registerer prometheus.Registerer, options ...Options) {
+	registerer = metrics.RegistererOrDefault(registerer)
+	opts := Options{}
+	for _, o := range options {
+		opts = o
+	}
+
+	for _, collector := range setupUpstream() {
+		if opts.Histogram && isReplacedByHistogram(collector) {
+			continue
+		}
+		metrics.Register(registerer, collector)
+	}
+
+	if !opts.Histogram {
+		auresthttpclient.Instrument(client, aurestclientprometheus.RequestMetricsCallback, aurestclientprometheus.ResponseMetricsCallback)
+		return
+	}
+
+	buckets := opts.Buckets
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	histogram := metrics.Register(registerer, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    HistogramName,
+			Help:    "How long it took to process downstream http requests by target hostname, method, outcome and response status.",
+			Buckets: buckets,
+		},
+		[]string{"clientName", "method", "outcome", "status"},
+	))
+	auresthttpclient.Instrument(client, aurestclientprometheus.RequestMetricsCallback, histogramCallback(histogram))
+}
+
+// setupUpstream sets up the aurestclientprometheus metrics once, catching the collectors it registers
+// on prometheus.DefaultRegisterer.
+func setupUpstream() []prometheus.Collector {
+	upstreamOnce.Do(func() {
+		original := prometheus.DefaultRegisterer
+		catcher := &catchingRegisterer{}
+		prometheus.DefaultRegisterer = catcher
+		defer func() {
+			prometheus.DefaultRegisterer = original
+		}()
+
+		aurestclientprometheus.SetupHttpClientMetrics()
+		upstreamCollectors = catcher.collectors
+	})
+	return upstreamCollectors
+}
+
+// histogramCallback records the response with aurestclientprometheus, and the latency in the histogram
+func histogramCallback(histogram *prometheus.HistogramVec) aurestclientapi.MetricsCallbackFunction {
+	return func(ctx context.Context, method string, requestUrl string, status int, err error, latency time.Duration, size int) {
+		aurestclientprometheus.ResponseMetricsCallback(ctx, method, requestUrl, status, err, latency, size)
+
+		clientName := aurestclientprometheus.ClientNameFromRequestUrl(requestUrl)
+		outcome := aurestclientprometheus.OutcomeFromStatus(status)
+		statusStr := fmt.Sprintf("%d", status)
+
+		// the histogram count replaces the counter, so always observe
+		seconds := float64(latency.Microseconds()) / 1000000
+		metrics.ObserveWithTrace(ctx, histogram.WithLabelValues(clientName, method, outcome, statusStr), seconds)
+	}
+}
+
+func isReplacedByHistogram(collector prometheus.Collector) bool {
+	descs := make(chan *prometheus.Desc, 1)
+	go func() {
+		collector.Describe(descs)
+		close(descs)
+	}()
+
+	replaced := false
+	for desc := range descs {
+		for _, name := range replacedByHistogram {
+			if strings.Contains(desc.String(), fmt.Sprintf("fqName: %q", name)) {
+				replaced = true
+			}
+		}
+	}
+	return replaced
+}
+
+// catchingRegisterer remembers the collectors registered on it instead of registering them
+type catchingRegisterer struct {
+	collectors []prometheus.Collector
+}
+
+func (c *catchingRegisterer) Register(collector prometheus.Collector) error {
+	c.collectors = append(c.collectors, collector)
+	return nil
+}
+
+func (c *catchingRegisterer) MustRegister(collectors ...prometheus.Collector) {
+	c.collectors = append(c.collectors, collectors...)
+}
+
+func (c *catchingRegisterer) Unregister(_ prometheus.Collector) bool {
+	return false
+}
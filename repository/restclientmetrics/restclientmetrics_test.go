@@ -0,0 +1,73 @@
+package restclientmetrics
+
+import (
+	"context"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInstrumentHttpClient_Registerer(t *testing.T) {
+	docs.Description("the aurestclientprometheus metrics are registered on the given registerer instead of the global registry")
+
+	server := tstServer()
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	histogramRegistry := prometheus.NewRegistry()
+	tstPerformRequest(t, server, registry, Options{})
+	tstPerformRequest(t, server, histogramRegistry, Options{Histogram: true})
+
+	names := tstMetricNames(t, registry)
+	require.Contains(t, names, "http_client_requests_seconds_count")
+	require.Contains(t, names, "http_client_requests_seconds_sum")
+	require.Contains(t, names, "http_client_requests_response_bytes_sum")
+	require.NotContains(t, names, HistogramName)
+
+	names = tstMetricNames(t, histogramRegistry)
+	require.Contains(t, names, HistogramName)
+	require.Contains(t, names, "http_client_requests_response_bytes_sum")
+	require.NotContains(t, names, "http_client_requests_seconds_count")
+	require.NotContains(t, names, "http_client_requests_seconds_sum")
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.Nil(t, err)
+	for _, family := range families {
+		require.NotContains(t, family.GetName(), "http_client_")
+	}
+}
+
+// --- helpers ---
+
+func tstServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	}))
+}
+
+func tstPerformRequest(t *testing.T, server *httptest.Server, registerer prometheus.Registerer, options Options) {
+	client, err := auresthttpclient.New(5*time.Second, nil, nil)
+	require.Nil(t, err)
+	InstrumentHttpClient(client, registerer, options)
+
+	response := aurestclientapi.ParsedResponse{Body: &map[string]interface{}{}}
+	require.Nil(t, client.Perform(context.Background(), http.MethodGet, server.URL, nil, &response))
+	require.Equal(t, http.StatusOK, response.Status)
+}
+
+func tstMetricNames(t *testing.T, registry *prometheus.Registry) []string {
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	names := make([]string, 0)
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	return names
+}
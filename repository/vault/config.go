@@ -9,6 +9,7 @@ import (
 	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
 	"github.com/StephanHCB/go-backend-service-common/repository/config"
 	"strconv"
+	"strings"
 )
 
 var ConfigItems = []auconfigapi.ConfigItem{
@@ -96,3 +97,37 @@ func parseSecretsConfig(jsonString string) (repository.VaultSecretsConfig, error
 	}
 	return secretsConfig, nil
 }
+
+// CheckSecretsConfig verifies that every secret in the secrets configuration is mapped to a known configuration key.
+//
+// For nested config keys of the form "key.subkey", only "key" needs to be known.
+//
+// Returns a list of all problems found, which is empty if the mapping is ok.
+func CheckSecretsConfig(secretsConfig repository.VaultSecretsConfig, knownKeys map[string]bool) []error {
+	errorList := make([]error, 0)
+	seen := make(map[string]string)
+	for path, secrets := range secretsConfig {
+		for _, secretConfig := range secrets {
+			if secretConfig.VaultKey == "" {
+				errorList = append(errorList, fmt.Errorf("vault path %s contains an entry with empty vaultKey", path))
+				continue
+			}
+
+			configKey := secretConfig.VaultKey
+			if secretConfig.ConfigKey != nil && *secretConfig.ConfigKey != "" {
+				configKey = *secretConfig.ConfigKey
+			}
+
+			if otherPath, ok := seen[configKey]; ok {
+				errorList = append(errorList, fmt.Errorf("config key %s is mapped from both vault path %s and %s", configKey, otherPath, path))
+			}
+			seen[configKey] = path
+
+			baseKey := strings.Split(configKey, ".")[0]
+			if !knownKeys[baseKey] {
+				errorList = append(errorList, fmt.Errorf("vault key %s at vault path %s is mapped to unknown config key %s", secretConfig.VaultKey, path, baseKey))
+			}
+		}
+	}
+	return errorList
+}
@@ -6,12 +6,15 @@ import (
 	"errors"
 	"fmt"
 	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
-	aurestclientprometheus "github.com/StephanHCB/go-autumn-restclient-prometheus"
+	auloggingapi "github.com/StephanHCB/go-autumn-logging/api"
 	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
 	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
 	aurestlogging "github.com/StephanHCB/go-autumn-restclient/implementation/requestlogging"
 	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/StephanHCB/go-backend-service-common/repository/logging"
+	"github.com/StephanHCB/go-backend-service-common/repository/restclientmetrics"
 	"github.com/go-http-utils/headers"
+	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
 	"os"
 	"strings"
@@ -21,6 +24,10 @@ import (
 type Impl struct {
 	Configuration repository.Configuration
 	Logging       repository.Logging
+	// Registerer is used for the client metrics, leave nil to use metrics.Registerer
+	Registerer prometheus.Registerer
+	// MetricsOptions configure the client metrics, e.g. histogram mode with exemplars
+	MetricsOptions restclientmetrics.Options
 
 	VaultEnabled                 bool
 	VaultProtocol                string
@@ -59,14 +66,27 @@ func (v *Impl) Setup(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	aurestclientprometheus.InstrumentHttpClient(client)
+	restclientmetrics.InstrumentHttpClient(client, v.Registerer, v.MetricsOptions)
 
-	logWrapper := aurestlogging.New(client)
+	logWrapper := aurestlogging.NewWithOptions(client, aurestlogging.RequestLoggingOptions{
+		BeforeRequest: namedLogger(aurestlogging.Debug),
+		Success:       namedLogger(aurestlogging.Info),
+		Failure:       namedLogger(aurestlogging.Warn),
+	})
 
 	v.VaultClient = logWrapper
 	return nil
 }
 
+// LoggerName is used for the request log entries of the vault client, so they can have their own log level
+var LoggerName = "vault"
+
+func namedLogger(leveled func(ctx context.Context) auloggingapi.LeveledLoggingImplementation) func(ctx context.Context) auloggingapi.LeveledLoggingImplementation {
+	return func(ctx context.Context) auloggingapi.LeveledLoggingImplementation {
+		return leveled(ctx).With(logging.LoggerNameFieldName, LoggerName)
+	}
+}
+
 func (v *Impl) publicCertOrNil() ([]byte, error) {
 	publicCertFilename := v.Configuration.VaultCertificateFile()
 
@@ -147,6 +167,7 @@ func (v *Impl) Authenticate(ctx context.Context) error {
 		}
 
 		v.VaultAuthToken = responseDto.Auth.ClientToken
+		v.Logging.Redact(v.VaultAuthToken)
 
 		return nil
 	}
@@ -170,6 +191,7 @@ func (v *Impl) ObtainSecrets(ctx context.Context) error {
 		for _, secretConfig := range secretsConfig {
 			vaultKey := secretConfig.VaultKey
 			if secret, ok := secrets[vaultKey]; ok {
+				v.Logging.Redact(secret)
 				configKey := vaultKey
 				if secretConfig.ConfigKey != nil && *secretConfig.ConfigKey != "" {
 					configKey = *secretConfig.ConfigKey
@@ -191,6 +213,17 @@ func (v *Impl) ObtainSecrets(ctx context.Context) error {
 	return nil
 }
 
+func (v *Impl) ObtainSecretsAt(ctx context.Context, path string) (map[string]string, error) {
+	secrets, err := v.lowlevelObtainSecrets(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		v.Logging.Redact(secret)
+	}
+	return secrets, nil
+}
+
 func (v *Impl) lowlevelObtainSecrets(ctx context.Context, fullSecretsPath string) (map[string]string, error) {
 	emptyMap := make(map[string]string)
 
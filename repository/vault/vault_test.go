@@ -107,6 +107,17 @@ func TestImpl_ObtainSecrets(t *testing.T) {
 	assert.Equal(t, testValues[key1], auconfigenv.Get(key1))
 }
 
+func TestImpl_ObtainSecretsAt(t *testing.T) {
+	cut := setupTest()
+
+	secrets, err := cut.ObtainSecretsAt(context.Background(), "path/to/second/secret")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{key3: testValues[key3]}, secrets)
+
+	_, err = cut.ObtainSecretsAt(context.Background(), "path/to/unknown")
+	assert.Error(t, err)
+}
+
 func Test_appendSecretToMap(t *testing.T) {
 	type args struct {
 		secretMapJson string
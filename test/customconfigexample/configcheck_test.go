@@ -0,0 +1,106 @@
+package customconfigexample
+
+import (
+	"bytes"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/StephanHCB/go-backend-service-common/repository/config/configcheck"
+	"github.com/StephanHCB/go-backend-service-common/repository/vault"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestConfigCheck_NoFlag_NotHandled(t *testing.T) {
+	docs.Description("configuration check does nothing without command line flags")
+
+	out := new(bytes.Buffer)
+	handled, _ := configcheck.Run(tstConfigWithVaultItems(), []string{"--something-else"}, out)
+	require.False(t, handled)
+	require.Equal(t, "", out.String())
+}
+
+func TestConfigCheck_Help(t *testing.T) {
+	docs.Description("configuration help lists all configuration items with description and default")
+
+	out := new(bytes.Buffer)
+	handled, exitCode := configcheck.Run(tstConfigWithVaultItems(), []string{configcheck.HelpFlag}, out)
+	require.True(t, handled)
+	require.Equal(t, 0, exitCode)
+
+	require.Contains(t, out.String(), "SERVER_PORT\n    port to listen on, cannot be a privileged port\n    default: '8080'\n")
+	require.Contains(t, out.String(), "LOG_LEVEL\n    minimum level of logged messages\n    default: 'INFO'\n    can be changed at runtime\n")
+	require.Contains(t, out.String(), "MY_CUSTOM_FIELD\n    an example custom config field\n")
+	require.Contains(t, out.String(), "VAULT_SECRETS_CONFIG\n")
+}
+
+func TestConfigCheck_Validate_Valid(t *testing.T) {
+	docs.Description("configuration validation succeeds for a valid configuration")
+
+	cut := tstConfigWithVaultItems()
+	auconfigenv.LocalConfigFileName = basedir + "valid-config-unique.yaml"
+
+	out := new(bytes.Buffer)
+	handled, exitCode := configcheck.Run(cut, []string{configcheck.ValidateFlag}, out)
+	require.True(t, handled)
+	require.Equal(t, 0, exitCode)
+	require.Equal(t, "configuration is valid\n", out.String())
+}
+
+func TestConfigCheck_Typed(t *testing.T) {
+	docs.Description("configuration checks also work for a typed configuration")
+
+	items := append([]auconfigapi.ConfigItem{}, CustomConfigItems...)
+	cut := config.NewTyped(&CustomConfigurationWithOneFieldImpl{}, items)
+	auconfigenv.LocalConfigFileName = basedir + "valid-config-unique.yaml"
+
+	out := new(bytes.Buffer)
+	handled, exitCode := configcheck.Run(cut, []string{configcheck.HelpFlag}, out)
+	require.True(t, handled)
+	require.Equal(t, 0, exitCode)
+	require.Contains(t, out.String(), "MY_CUSTOM_FIELD\n    an example custom config field\n")
+
+	out = new(bytes.Buffer)
+	handled, exitCode = configcheck.Run(cut, []string{configcheck.ValidateFlag}, out)
+	require.True(t, handled)
+	require.Equal(t, 0, exitCode)
+	require.Equal(t, "configuration is valid\n", out.String())
+}
+
+func TestConfigCheck_Validate_LotsOfErrors(t *testing.T) {
+	docs.Description("configuration validation reports all errors and fails")
+
+	cut := tstConfigWithVaultItems()
+	auconfigenv.LocalConfigFileName = basedir + "invalid-config-values.yaml"
+
+	out := new(bytes.Buffer)
+	handled, exitCode := configcheck.Run(cut, []string{configcheck.ValidateFlag}, out)
+	require.True(t, handled)
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, out.String(), "configuration field SERVER_PORT: value 122834 is out of range [1024..65535]\n")
+	require.Contains(t, out.String(), "configuration is invalid. There were 7 error(s)\n")
+}
+
+func TestConfigCheck_Validate_VaultMapping(t *testing.T) {
+	docs.Description("configuration validation reports vault secrets mapped to unknown configuration keys")
+
+	cut := tstConfigWithVaultItems()
+	auconfigenv.LocalConfigFileName = basedir + "invalid-config-vault-mapping.yaml"
+
+	out := new(bytes.Buffer)
+	handled, exitCode := configcheck.Run(cut, []string{configcheck.ValidateFlag}, out)
+	require.True(t, handled)
+	require.Equal(t, 1, exitCode)
+	require.Contains(t, out.String(), "vault key some-secret at vault path room-service/secrets is mapped to unknown config key UNKNOWN_KEY\n")
+	require.Contains(t, out.String(), "configuration is invalid. There were 1 error(s)\n")
+}
+
+// --- helpers ---
+
+func tstConfigWithVaultItems() repository.Configuration {
+	items := append([]auconfigapi.ConfigItem{}, CustomConfigItems...)
+	items = append(items, vault.ConfigItems...)
+	return config.NewNoAcorn(&CustomConfigurationWithOneFieldImpl{}, items)
+}
@@ -3,6 +3,7 @@ package customconfigexample
 import (
 	"bytes"
 	"context"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
 	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
 	goauzerolog "github.com/StephanHCB/go-autumn-logging-zerolog"
 	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
@@ -12,6 +13,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/require"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -33,8 +35,10 @@ func TestRead_EnvWins(t *testing.T) {
 }
 
 func tstSetupCutAndLogRecorder(t *testing.T, configfile string) (repository.Configuration, error) {
-	cut := New().(repository.Configuration)
+	return tstSetupLogRecorder(t, New().(repository.Configuration), configfile)
+}
 
+func tstSetupLogRecorder(t *testing.T, cut repository.Configuration, configfile string) (repository.Configuration, error) {
 	// Phase --- AssembleAcorn ---
 
 	auconfigenv.LocalConfigFileName = basedir + configfile
@@ -93,6 +97,17 @@ func TestValidate_LotsOfErrors(t *testing.T) {
 	require.Contains(t, actualLog, expectedPart4)
 }
 
+func TestValidate_BasicAuthUsernameWithDot(t *testing.T) {
+	docs.Description("basic auth usernames must not contain dots, because their password hash could not be set from vault")
+
+	t.Setenv(config.KeyBasicAuthUsers, `[{"username": "ci.bot"}]`)
+	_, err := tstSetupCutAndLogRecorder(t, "valid-config-unique.yaml")
+	require.NotNil(t, err)
+
+	actualLog := goauzerolog.RecordedLogForTesting.String()
+	require.Contains(t, actualLog, "\"message\":\"failed to validate configuration field BASIC_AUTH_USERS: basic auth user 'ci.bot' must not contain '.'")
+}
+
 func TestAccessors(t *testing.T) {
 	docs.Description("the config accessors return the correct values")
 
@@ -121,3 +136,177 @@ func TestAccessors(t *testing.T) {
 
 	require.Equal(t, "kitty", cut.Custom().(CustomConfigurationWithOneField).MyCustomField())
 }
+
+func TestReload_ChangesReloadableValues(t *testing.T) {
+	docs.Description("reloading the configuration changes reloadable values and notifies subscribers")
+
+	cut, err := tstSetupCutAndLogRecorder(t, "valid-config-unique.yaml")
+	require.Nil(t, err)
+
+	var received []repository.ConfigChangeEvent
+	cut.Subscribe(func(_ context.Context, events []repository.ConfigChangeEvent) {
+		received = events
+	})
+
+	auconfigenv.LocalConfigFileName = basedir + "valid-config-reload.yaml"
+	err = cut.Reload(log.Logger.WithContext(context.Background()))
+	require.Nil(t, err)
+
+	require.Equal(t, "DEBUG", cut.LogLevel())
+	require.Equal(t, "http://localhost:23456", cut.CorsAllowOrigin())
+	require.Equal(t, uint16(8081), cut.ServerPort())
+	require.ElementsMatch(t, []repository.ConfigChangeEvent{
+		{Key: config.KeyLogLevel, OldValue: "INFO", NewValue: "DEBUG"},
+		{Key: config.KeyCorsAllowOrigin, OldValue: "http://localhost:12345", NewValue: "http://localhost:23456"},
+	}, received)
+
+	actualLog := goauzerolog.RecordedLogForTesting.String()
+	require.Contains(t, actualLog, "configuration field SERVER_PORT changed, but cannot be changed at runtime")
+}
+
+func TestReload_InvalidKeepsPreviousValues(t *testing.T) {
+	docs.Description("reloading an invalid configuration keeps all previous values")
+
+	cut, err := tstSetupCutAndLogRecorder(t, "valid-config-unique.yaml")
+	require.Nil(t, err)
+
+	notified := false
+	cut.Subscribe(func(_ context.Context, _ []repository.ConfigChangeEvent) {
+		notified = true
+	})
+
+	auconfigenv.LocalConfigFileName = basedir + "invalid-config-reload.yaml"
+	err = cut.Reload(log.Logger.WithContext(context.Background()))
+	require.NotNil(t, err)
+
+	require.False(t, notified)
+	require.Equal(t, "INFO", cut.LogLevel())
+	require.Equal(t, "http://localhost:12345", cut.CorsAllowOrigin())
+	require.Equal(t, "INFO", auconfigenv.Get(config.KeyLogLevel))
+}
+
+func TestReload_CustomReloadableItem(t *testing.T) {
+	docs.Description("custom configuration items marked reloadable may change at runtime")
+
+	items := append([]auconfigapi.ConfigItem{}, CustomConfigItems...)
+	items = append(items, config.Reloadable(auconfigapi.ConfigItem{
+		Key:         "MY_FEATURE_TOGGLE",
+		EnvName:     "MY_FEATURE_TOGGLE",
+		Default:     "off",
+		Description: "an example feature toggle",
+		Validate:    auconfigapi.ConfigNeedsNoValidation,
+	}))
+	cut, err := tstSetupLogRecorder(t, config.NewNoAcorn(&CustomConfigurationWithOneFieldImpl{}, items), "valid-config-unique.yaml")
+	require.Nil(t, err)
+	require.True(t, cut.(*config.ConfigImpl).IsReloadable("MY_FEATURE_TOGGLE"))
+	require.False(t, cut.(*config.ConfigImpl).IsReloadable(KeyMyCustomField))
+
+	var received []repository.ConfigChangeEvent
+	cut.Subscribe(func(_ context.Context, events []repository.ConfigChangeEvent) {
+		received = events
+	})
+
+	t.Setenv("MY_FEATURE_TOGGLE", "on")
+	require.Nil(t, cut.Reload(log.Logger.WithContext(context.Background())))
+	require.Equal(t, []repository.ConfigChangeEvent{{Key: "MY_FEATURE_TOGGLE", OldValue: "off", NewValue: "on"}}, received)
+	require.Equal(t, "on", auconfigenv.Get("MY_FEATURE_TOGGLE"))
+}
+
+func TestReload_UnknownKeysNotRepeated(t *testing.T) {
+	docs.Description("reloading does not report unknown configuration keys over and over")
+
+	cut, err := tstSetupCutAndLogRecorder(t, "valid-config-unique.yaml")
+	require.Nil(t, err)
+
+	ctx := log.Logger.WithContext(context.Background())
+	auconfigenv.LocalConfigFileName = basedir + "valid-config-reload-unknown.yaml"
+	require.Nil(t, cut.Reload(ctx))
+	auconfigenv.LocalConfigFileName = basedir + "valid-config-reload-unknown-again.yaml"
+	require.Nil(t, cut.Reload(ctx))
+
+	require.Equal(t, "WARN", cut.LogLevel())
+	require.NotContains(t, goauzerolog.RecordedLogForTesting.String(), "unknown configuration key")
+}
+
+func TestReload_Concurrent(t *testing.T) {
+	docs.Description("concurrent reloads and reads of the configuration do not race")
+
+	cut, err := tstSetupCutAndLogRecorder(t, "valid-config-unique.yaml")
+	require.Nil(t, err)
+	previousCustom := cut.Custom()
+
+	auconfigenv.LocalConfigFileName = basedir + "valid-config-reload.yaml"
+	ctx := log.Logger.WithContext(context.Background())
+	var wg sync.WaitGroup
+	reloadErrors := make(chan error, 4)
+	customFields := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			reloadErrors <- cut.Reload(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = cut.LogLevel()
+			_ = cut.BasicAuthUsers()
+			_ = cut.ApiKeys()
+			customFields <- cut.Custom().(CustomConfigurationWithOneField).MyCustomField()
+		}()
+	}
+	wg.Wait()
+	close(reloadErrors)
+	close(customFields)
+
+	for err := range reloadErrors {
+		require.Nil(t, err)
+	}
+	for customField := range customFields {
+		require.Equal(t, "kitty", customField)
+	}
+
+	require.Equal(t, "DEBUG", cut.LogLevel())
+	require.NotSame(t, previousCustom, cut.Custom())
+	require.Equal(t, "kitty", cut.Custom().(CustomConfigurationWithOneField).MyCustomField())
+}
+
+func TestCustomAs(t *testing.T) {
+	docs.Description("custom configuration can be obtained type safely")
+
+	cut, err := tstSetupCutAndLogRecorder(t, "valid-config-unique.yaml")
+	require.Nil(t, err)
+
+	custom, err := config.CustomAs[CustomConfigurationWithOneField](cut)
+	require.Nil(t, err)
+	require.Equal(t, "kitty", custom.MyCustomField())
+
+	_, err = config.CustomAs[*tstOtherCustomConfiguration](cut)
+	require.NotNil(t, err)
+	require.Equal(t, "cannot obtain custom configuration as *customconfigexample.tstOtherCustomConfiguration: it is of type *customconfigexample.CustomConfigurationWithOneFieldImpl", err.Error())
+
+	_, err = config.CustomAs[CustomConfigurationWithOneField](nil)
+	require.NotNil(t, err)
+	require.Equal(t, "cannot obtain custom configuration as customconfigexample.CustomConfigurationWithOneField: configuration is nil", err.Error())
+}
+
+func TestNewTyped(t *testing.T) {
+	docs.Description("a typed configuration gives type safe access to the custom configuration")
+
+	cut := config.NewTyped[CustomConfigurationWithOneField](&CustomConfigurationWithOneFieldImpl{}, CustomConfigItems)
+	auconfigenv.LocalConfigFileName = basedir + "valid-config-unique.yaml"
+	require.Nil(t, cut.Read())
+	cut.CustomConfiguration.Obtain(auconfigenv.Get)
+
+	typed, err := cut.Typed()
+	require.Nil(t, err)
+	require.Equal(t, "kitty", typed.MyCustomField())
+
+	empty := config.NewTyped[CustomConfigurationWithOneField](nil, CustomConfigItems)
+	_, err = empty.Typed()
+	require.NotNil(t, err)
+	require.Equal(t, "cannot obtain custom configuration as customconfigexample.CustomConfigurationWithOneField: it is of type <nil>", err.Error())
+}
+
+type tstOtherCustomConfiguration struct{}
+
+func (c *tstOtherCustomConfiguration) Obtain(_ func(key string) string) {}
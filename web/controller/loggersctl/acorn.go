@@ -0,0 +1,50 @@
+package loggersctl
+
+import (
+	"github.com/StephanHCB/go-autumn-acorn-registry/api"
+	"github.com/StephanHCB/go-backend-service-common/acorns/controller"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+)
+
+// --- implementing Acorn ---
+
+func New() auacornapi.Acorn {
+	return &LoggersCtlImpl{}
+}
+
+// NewNoAcorn wires up the component, but does not set it up.
+//
+// You still need to call Setup() after the configuration has been set up.
+func NewNoAcorn(configuration repository.Configuration, logging repository.Logging) controller.LoggersController {
+	return &LoggersCtlImpl{
+		Configuration: configuration,
+		Logging:       logging,
+	}
+}
+
+func (c *LoggersCtlImpl) IsLoggersController() bool {
+	return true
+}
+
+func (c *LoggersCtlImpl) AcornName() string {
+	return controller.LoggersControllerAcornName
+}
+
+func (c *LoggersCtlImpl) AssembleAcorn(registry auacornapi.AcornRegistry) error {
+	c.Configuration = registry.GetAcornByName(repository.ConfigurationAcornName).(repository.Configuration)
+	c.Logging = registry.GetAcornByName(repository.LoggingAcornName).(repository.Logging)
+
+	return nil
+}
+
+func (c *LoggersCtlImpl) SetupAcorn(registry auacornapi.AcornRegistry) error {
+	if err := registry.SetupAfter(c.Configuration.(auacornapi.Acorn)); err != nil {
+		return err
+	}
+
+	return c.Setup()
+}
+
+func (c *LoggersCtlImpl) TeardownAcorn(registry auacornapi.AcornRegistry) error {
+	return nil
+}
@@ -0,0 +1,147 @@
+package loggersctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/StephanHCB/go-backend-service-common/api"
+	"github.com/StephanHCB/go-backend-service-common/api/apierrors"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/StephanHCB/go-backend-service-common/web/middleware/security"
+	"github.com/StephanHCB/go-backend-service-common/web/util/media"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+	"net/http"
+	"time"
+)
+
+var ConfigItems = []auconfigapi.ConfigItem{
+	{
+		Key:         config.KeyLoggersAdminGroup,
+		EnvName:     config.KeyLoggersAdminGroup,
+		Default:     "",
+		Description: "group a user must have to view or change log levels at runtime. Leave blank to refuse access to everyone.",
+		Validate:    auconfigapi.ConfigNeedsNoValidation,
+	},
+}
+
+// maxTtlSeconds limits how long a changed log level may stay in effect before it reverts, one day
+const maxTtlSeconds = 24 * 60 * 60
+
+type LoggersCtlImpl struct {
+	Configuration repository.Configuration
+	Logging       repository.Logging
+
+	AdminGroup string
+}
+
+func (c *LoggersCtlImpl) Setup() error {
+	c.AdminGroup = auconfigenv.Get(config.KeyLoggersAdminGroup)
+	return nil
+}
+
+func (c *LoggersCtlImpl) WireUp(ctx context.Context, router chi.Router) {
+	router.Get("/management/loggers", c.GetLoggers)
+	router.Get("/management/loggers/{name}", c.GetLogger)
+	router.Post("/management/loggers/{name}", c.PostLogger)
+}
+
+func (c *LoggersCtlImpl) GetLoggers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := c.checkAccess(ctx); err != nil {
+		apierrors.HandleError(ctx, w, r, err, apierrors.IsUnauthorisedError, apierrors.IsForbiddenError)
+		return
+	}
+
+	response := api.LoggersDto{
+		Levels:  []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"},
+		Loggers: make(map[string]api.LoggerLevelDto),
+	}
+	for name, info := range c.Logging.Levels() {
+		response.Loggers[name] = loggerLevelDto(info)
+	}
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	security.WriteJson(ctx, w, response)
+}
+
+func (c *LoggersCtlImpl) GetLogger(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := c.checkAccess(ctx); err != nil {
+		apierrors.HandleError(ctx, w, r, err, apierrors.IsUnauthorisedError, apierrors.IsForbiddenError)
+		return
+	}
+	name := chi.URLParam(r, "name")
+	info, ok := c.Logging.Levels()[name]
+	if !ok {
+		err := apierrors.NewNotFoundError("logger.notfound", fmt.Sprintf("no logger with name %s has a level of its own", name), nil, time.Now())
+		apierrors.HandleError(ctx, w, r, err, apierrors.IsNotFoundError)
+		return
+	}
+
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	security.WriteJson(ctx, w, loggerLevelDto(info))
+}
+
+func (c *LoggersCtlImpl) PostLogger(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := c.checkAccess(ctx); err != nil {
+		apierrors.HandleError(ctx, w, r, err, apierrors.IsUnauthorisedError, apierrors.IsForbiddenError)
+		return
+	}
+	name := chi.URLParam(r, "name")
+
+	dto := api.LoggerLevelChangeDto{}
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		apierrors.HandleError(ctx, w, r, apierrors.NewBadRequestError("logger.invalid", "body must be a valid json object", err, time.Now()), apierrors.IsBadRequestError)
+		return
+	}
+
+	level := ""
+	if dto.ConfiguredLevel != nil {
+		level = *dto.ConfiguredLevel
+	}
+	ttl := time.Duration(0)
+	if dto.TtlSeconds != nil {
+		if *dto.TtlSeconds < 0 || *dto.TtlSeconds > maxTtlSeconds {
+			apierrors.HandleError(ctx, w, r, apierrors.NewBadRequestError("logger.invalid", fmt.Sprintf("ttlSeconds must be between 0 and %d", maxTtlSeconds), nil, time.Now()), apierrors.IsBadRequestError)
+			return
+		}
+		ttl = time.Duration(*dto.TtlSeconds) * time.Second
+	}
+
+	if err := c.Logging.SetLevel(ctx, name, level, ttl); err != nil {
+		apierrors.HandleError(ctx, w, r, apierrors.NewBadRequestError("logger.invalid", err.Error(), err, time.Now()), apierrors.IsBadRequestError)
+		return
+	}
+	c.Logging.Logger().Ctx(ctx).Info().Printf("log level of logger %s set to '%s' (ttl %s) by subject %s, name %s", name, level, ttl.String(), security.Subject(ctx), security.Name(ctx))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *LoggersCtlImpl) checkAccess(ctx context.Context) error {
+	if err := security.IsAuthenticated(ctx, "loggers management endpoint", time.Now()); err != nil {
+		return err
+	}
+	if c.AdminGroup == "" {
+		c.Logging.Logger().Ctx(ctx).Info().Print("forbidden: no admin group configured for loggers management endpoint")
+		return apierrors.NewForbiddenError("forbidden", "you are not authorized for this operation", nil, time.Now())
+	}
+	if err := security.HasGroup(ctx, c.AdminGroup, "loggers management endpoint", time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func loggerLevelDto(info repository.LogLevelInfo) api.LoggerLevelDto {
+	dto := api.LoggerLevelDto{
+		EffectiveLevel: &info.EffectiveLevel,
+		RevertAt:       info.RevertAt,
+	}
+	if info.ConfiguredLevel != "" {
+		dto.ConfiguredLevel = &info.ConfiguredLevel
+	}
+	return dto
+}
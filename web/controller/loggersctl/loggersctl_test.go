@@ -0,0 +1,132 @@
+package loggersctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/StephanHCB/go-backend-service-common/api"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/repository/config"
+	"github.com/StephanHCB/go-backend-service-common/repository/logging"
+	"github.com/StephanHCB/go-backend-service-common/web/middleware/security/securitytest"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggers_Access(t *testing.T) {
+	docs.Description("the loggers endpoint requires authentication and the configured admin group")
+
+	cut := tstSetupCut(t)
+	noAdminGroup := tstSetupCut(t)
+	noAdminGroup.AdminGroup = ""
+
+	testcases := []struct {
+		name   string
+		cut    *LoggersCtlImpl
+		claims *securitytest.ContextBuilder
+		status int
+	}{
+		{"unauthenticated", cut, nil, http.StatusUnauthorized},
+		{"without group", cut, securitytest.NewContext().WithGroups("users"), http.StatusForbidden},
+		{"with group", cut, securitytest.NewContext().WithGroups("users", "log-admins"), http.StatusOK},
+		{"no admin group configured", noAdminGroup, securitytest.NewContext().WithGroups("log-admins"), http.StatusForbidden},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := tstPerformRequest(tc.cut, tc.claims, http.MethodGet, "/management/loggers", "")
+			require.Equal(t, tc.status, response.Code)
+
+			response = tstPerformRequest(tc.cut, tc.claims, http.MethodPost, "/management/loggers/vault", `{"configuredLevel": "DEBUG"}`)
+			if tc.status == http.StatusOK {
+				require.Equal(t, http.StatusNoContent, response.Code)
+			} else {
+				require.Equal(t, tc.status, response.Code)
+			}
+		})
+	}
+}
+
+func TestLoggers_InvalidChange(t *testing.T) {
+	docs.Description("invalid level names and ttls are rejected")
+
+	cut := tstSetupCut(t)
+	admin := securitytest.NewContext().WithGroups("log-admins")
+
+	for _, body := range []string{
+		`{"configuredLevel": "LOUD"}`,
+		`{"configuredLevel": "DEBUG", "ttlSeconds": -1}`,
+		fmt.Sprintf(`{"configuredLevel": "DEBUG", "ttlSeconds": %d}`, maxTtlSeconds+1),
+		`{"configuredLevel": "DEBUG", "ttlSeconds": 9223372036854775807}`,
+		`not json`,
+	} {
+		response := tstPerformRequest(cut, admin, http.MethodPost, "/management/loggers/vault", body)
+		require.Equal(t, http.StatusBadRequest, response.Code, body)
+	}
+
+	response := tstPerformRequest(cut, admin, http.MethodGet, "/management/loggers/vault", "")
+	require.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestLoggers_TtlRevert(t *testing.T) {
+	docs.Description("a log level changed with a ttl reverts to the configured level")
+
+	cut := tstSetupCut(t)
+	admin := securitytest.NewContext().WithGroups("log-admins")
+
+	response := tstPerformRequest(cut, admin, http.MethodPost, "/management/loggers/vault", `{"configuredLevel": "DEBUG", "ttlSeconds": 1}`)
+	require.Equal(t, http.StatusNoContent, response.Code)
+
+	response = tstPerformRequest(cut, admin, http.MethodGet, "/management/loggers/vault", "")
+	require.Equal(t, http.StatusOK, response.Code)
+	dto := api.LoggerLevelDto{}
+	require.Nil(t, json.Unmarshal(response.Body.Bytes(), &dto))
+	require.Equal(t, "DEBUG", *dto.EffectiveLevel)
+	require.NotNil(t, dto.RevertAt)
+
+	require.Eventually(t, func() bool {
+		return tstPerformRequest(cut, admin, http.MethodGet, "/management/loggers/vault", "").Code == http.StatusNotFound
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+// --- helpers ---
+
+func tstSetupCut(t *testing.T) *LoggersCtlImpl {
+	t.Setenv(config.KeyLogLevel, "INFO")
+	t.Setenv(config.KeyLogLevels, "")
+	auconfigenv.LocalConfigFileName = "does-not-exist.yaml"
+
+	configuration := config.NewNoAcorn(nil, nil)
+	logger := &logging.LoggingImpl{
+		Configuration: configuration,
+		Registerer:    prometheus.NewRegistry(),
+	}
+	logger.SetupForTesting()
+	require.Nil(t, configuration.Assemble(logger))
+	logger.Setup()
+
+	return &LoggersCtlImpl{
+		Configuration: configuration,
+		Logging:       logger,
+		AdminGroup:    "log-admins",
+	}
+}
+
+func tstPerformRequest(cut *LoggersCtlImpl, claims *securitytest.ContextBuilder, method string, path string, body string) *httptest.ResponseRecorder {
+	router := chi.NewRouter()
+	cut.WireUp(context.Background(), router)
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if claims != nil {
+		request = claims.Request(request)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	return response
+}
@@ -12,6 +12,7 @@ import (
 	"go.elastic.co/apm/module/apmchiv5/v2"
 	"go.elastic.co/apm/v2"
 	"net/http"
+	"sync"
 )
 
 type ApmMiddlewareOptions struct {
@@ -19,16 +20,21 @@ type ApmMiddlewareOptions struct {
 	PlainLogging      bool
 }
 
+// the default tracer is global, so we only replace it once, even with multiple middleware stacks
+var discardTracerOnce sync.Once
+
 func BuildApmMiddleware(ctx context.Context, options ApmMiddlewareOptions) func(http.Handler) http.Handler {
 	// add apm middleware, because we rely on having a trace context in the context for trace logging and trace propagation to work.
 	if !options.ElasticApmEnabled {
 		// if apm is not configured, we use a discardTracer that does not send any traces
-		err := auapmmiddleware.SetupDiscardTracer()
-		if err != nil {
-			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Print("setting up discard tracer failed - continuing with default tracer: %s", err.Error())
-		} else {
-			aulogging.Logger.Ctx(ctx).Info().Print("successfully set up discard tracer because Elastic APM is not configured")
-		}
+		discardTracerOnce.Do(func() {
+			err := auapmmiddleware.SetupDiscardTracer()
+			if err != nil {
+				aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Print("setting up discard tracer failed - continuing with default tracer: %s", err.Error())
+			} else {
+				aulogging.Logger.Ctx(ctx).Info().Print("successfully set up discard tracer because Elastic APM is not configured")
+			}
+		})
 	}
 
 	return GetApmMiddlewareAfterSetup(ctx, options)
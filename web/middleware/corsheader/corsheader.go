@@ -30,9 +30,17 @@ func CorsHandling(next http.Handler) http.Handler {
 //
 // Leaving the configuration value at its default of "" will switch off the
 // CORS middleware completely.
+//
+// The configuration value is looked up for every request, so changes made by a configuration
+// reload take effect immediately.
 func CorsHandlingWithConfig(configuration repository.Configuration) func(http.Handler) http.Handler {
-	allowOrigin := configuration.CorsAllowOrigin()
-	return CorsHandlingWithCorsAllowOrigin(allowOrigin)
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			allowOrigin := configuration.CorsAllowOrigin()
+			CorsHandlingWithCorsAllowOrigin(allowOrigin)(next).ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
 }
 
 // CorsHandlingWithCorsAllowOrigin creates a middleware for CORS headers.
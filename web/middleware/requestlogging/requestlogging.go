@@ -21,11 +21,18 @@ type Options struct {
 	ExcludeLogging []string
 }
 
+// Setup replaces chi's middleware.DefaultLogger, which is a global setting.
 func Setup(options ...Options) {
-	setupWithOpts(options)
+	middleware.DefaultLogger = Middleware(options...)
 }
 
-func setupWithOpts(options []Options) {
+// Middleware constructs a request logging middleware, which you can use instead of Setup() if you
+// need more than one, e.g. for a second middleware stack with different options.
+func Middleware(options ...Options) func(next http.Handler) http.Handler {
+	return middleware.RequestLogger(newFormatter(options))
+}
+
+func newFormatter(options []Options) *zerologLogFormatter {
 	excludeRegexes := make([]*regexp.Regexp, 0)
 	for _, opts := range options {
 		for _, pattern := range opts.ExcludeLogging {
@@ -39,9 +46,9 @@ func setupWithOpts(options []Options) {
 		}
 	}
 
-	middleware.DefaultLogger = middleware.RequestLogger(&zerologLogFormatter{
+	return &zerologLogFormatter{
 		excludeRegexes: excludeRegexes,
-	})
+	}
 }
 
 // --- implement middleware.LogFormatter
@@ -109,9 +116,10 @@ func (l *zerologLogEntry) Write(status, bytes int, header http.Header, elapsed t
 			With(LoggerNameFieldName, "request.incoming").
 			Print(msg)
 	} else {
-		// console friendly version
+		// console friendly version (the logger name is not shown, but still needed for per logger name levels)
 		msg = fmt.Sprintf("request %s %s -> %d (%d μs)", l.method, l.path, status, elapsed.Microseconds())
-		e.Print(msg)
+		e.With(LoggerNameFieldName, "request.incoming").
+			Print(msg)
 	}
 }
 
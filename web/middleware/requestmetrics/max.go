@@ -0,0 +1,99 @@
+package requestmetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// windowMaxBuffers is the number of rotating buffers, same as in Micrometer's TimeWindowMax
+const windowMaxBuffers = 3
+
+// maxCollector reports the maximum observed value per label combination over a sliding time window.
+//
+// This is what Spring reports as http_server_requests_seconds_max. Each observation is recorded in all
+// buffers, and every window/windowMaxBuffers the oldest buffer is reset, so a maximum decays after at most window.
+type maxCollector struct {
+	desc   *prometheus.Desc
+	window time.Duration
+	now    func() time.Time
+
+	lock   sync.Mutex
+	values map[string]*windowMax
+}
+
+type windowMax struct {
+	labelValues []string
+	buffers     [windowMaxBuffers]float64
+	current     int
+	lastRotate  time.Time
+}
+
+func newMaxCollector(name string, help string, labelNames []string, window time.Duration) *maxCollector {
+	return &maxCollector{
+		desc:   prometheus.NewDesc(name, help, labelNames, nil),
+		window: window,
+		now:    time.Now,
+		values: make(map[string]*windowMax),
+	}
+}
+
+func (c *maxCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *maxCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	for _, value := range c.values {
+		c.rotate(value, now)
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, value.buffers[value.current], value.labelValues...)
+	}
+}
+
+func (c *maxCollector) Observe(observed float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	value, ok := c.values[key]
+	if !ok {
+		value = &windowMax{
+			labelValues: labelValues,
+			lastRotate:  now,
+		}
+		c.values[key] = value
+	}
+
+	c.rotate(value, now)
+	for i := range value.buffers {
+		if observed > value.buffers[i] {
+			value.buffers[i] = observed
+		}
+	}
+}
+
+func (c *maxCollector) rotate(value *windowMax, now time.Time) {
+	rotateEvery := c.window / windowMaxBuffers
+	if rotateEvery <= 0 {
+		return
+	}
+
+	if now.Sub(value.lastRotate) >= c.window {
+		// idle for a whole window, everything has decayed
+		value.buffers = [windowMaxBuffers]float64{}
+		value.lastRotate = now
+		return
+	}
+
+	for now.Sub(value.lastRotate) >= rotateEvery {
+		value.buffers[value.current] = 0
+		value.current = (value.current + 1) % windowMaxBuffers
+		value.lastRotate = value.lastRotate.Add(rotateEvery)
+	}
+}
@@ -2,9 +2,12 @@ package requestmetrics
 
 import (
 	"fmt"
+	"github.com/StephanHCB/go-backend-service-common/api/apierrors"
+	"github.com/StephanHCB/go-backend-service-common/repository/metrics"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -13,43 +16,229 @@ import (
 var (
 	RequestCounterName  = "http_server_requests_seconds_count"
 	RequestDurationName = "http_server_requests_seconds_sum"
+	// RequestHistogramName is used instead of RequestCounterName and RequestDurationName in histogram mode
+	RequestHistogramName = "http_server_requests_seconds"
+	RequestMaxName       = "http_server_requests_seconds_max"
+	UriCollapsedName     = "http_server_requests_uri_collapsed_total"
+	ActiveRequestsName   = "http_server_requests_active"
+	RequestBytesName     = "http_server_requests_request_bytes"
+	ResponseBytesName    = "http_server_requests_response_bytes"
+	SlowRequestsName     = "http_server_requests_slow_total"
 
-	reqs    *prometheus.CounterVec
-	latency *prometheus.SummaryVec
+	defaultMetrics *RequestMetrics
 )
 
-func Setup() {
-	reqs = prometheus.NewCounterVec(
+var labelNames = []string{"method", "outcome", "status", "uri", "exception"}
+
+type Options struct {
+	// Histogram switches from a counter and a summary to a histogram, so quantiles can be aggregated across instances.
+	//
+	// The histogram is named like in Spring with percentile histograms enabled, so it provides
+	// http_server_requests_seconds_bucket, _count and _sum.
+	//
+	// Observations carry the APM trace.id as an exemplar, so Grafana can link from a latency spike to a trace.
+	// Serve the metrics with metrics.Handler to expose them.
+	Histogram bool
+	// Buckets are the upper bounds of the histogram buckets in seconds, default prometheus.DefBuckets
+	Buckets []float64
+
+	// MaxWindow is how long http_server_requests_seconds_max remembers the maximum duration, default 2 minutes (as in Spring)
+	MaxWindow time.Duration
+
+	// AllowedUris is the optional list of route patterns that may appear in the uri label, e.g. "/v1/things/{id}".
+	//
+	// Requests for any other route are recorded with uri OTHER. Requests that match no route at all
+	// are always recorded with uri NOT_FOUND, REDIRECTION or UNKNOWN.
+	AllowedUris []string
+	// MaxUris limits the number of distinct values of the uri label, further route patterns are recorded as OTHER. 0 means no limit
+	MaxUris int
+
+	// The in-flight gauge http_server_requests_active is always recorded and has no options. It is partitioned
+	// by method only: the uri label is the matched route pattern, which chi only knows after routing, but a
+	// request counts as in flight from before routing, so the gauge could not be labelled when it is increased.
+
+	// SizeBuckets are the upper bounds of the request and response body size histogram buckets in bytes, default 100 bytes to 10 MB
+	SizeBuckets []float64
+
+	// SlowThreshold enables counting requests that take longer than this. 0 switches the counter off
+	SlowThreshold time.Duration
+}
+
+// RequestMetrics records metrics about incoming requests on a prometheus registerer.
+type RequestMetrics struct {
+	reqs      *prometheus.CounterVec
+	latency   *prometheus.SummaryVec
+	histogram *prometheus.HistogramVec
+	max       *maxCollector
+	collapsed *prometheus.CounterVec
+	uris      *uriLimiter
+
+	active        *prometheus.GaugeVec
+	requestBytes  *prometheus.HistogramVec
+	responseBytes *prometheus.HistogramVec
+
+	slow          *prometheus.CounterVec
+	slowThreshold time.Duration
+}
+
+// New registers the request metrics on the given registerer, or on metrics.Registerer if nil.
+//
+// Can be called more than once for the same registerer and options, e.g. for a second middleware stack.
+func New(registerer prometheus.Registerer, options ...Options) *RequestMetrics {
+	registerer = metrics.RegistererOrDefault(registerer)
+
+	opts := Options{}
+	for _, o := range options {
+		opts = o
+	}
+	if opts.MaxWindow <= 0 {
+		opts.MaxWindow = 2 * time.Minute
+	}
+
+	m := &RequestMetrics{
+		max: metrics.Register(registerer, newMaxCollector(
+			RequestMaxName,
+			"Maximum time it took to process requests in the recent past, partitioned by status code, method and HTTP path (grouped by patterns).",
+			labelNames,
+			opts.MaxWindow,
+		)),
+		collapsed: metrics.Register(registerer, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: UriCollapsedName,
+				Help: "Number of incoming HTTP requests whose route pattern was replaced in the uri label, partitioned by the replacement.",
+			},
+			[]string{"uri"},
+		)),
+		uris: newUriLimiter(opts.AllowedUris, opts.MaxUris),
+	}
+
+	sizeBuckets := opts.SizeBuckets
+	if len(sizeBuckets) == 0 {
+		sizeBuckets = prometheus.ExponentialBuckets(100, 10, 6)
+	}
+	m.active = metrics.Register(registerer, prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: ActiveRequestsName,
+			Help: "Number of incoming HTTP requests currently being processed, partitioned by method.",
+		},
+		[]string{"method"},
+	))
+	m.requestBytes = metrics.Register(registerer, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    RequestBytesName,
+			Help:    "Size of the request body, partitioned by method and HTTP path (grouped by patterns).",
+			Buckets: sizeBuckets,
+		},
+		[]string{"method", "uri"},
+	))
+	m.responseBytes = metrics.Register(registerer, prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    ResponseBytesName,
+			Help:    "Size of the response body, partitioned by method and HTTP path (grouped by patterns).",
+			Buckets: sizeBuckets,
+		},
+		[]string{"method", "uri"},
+	))
+
+	if opts.SlowThreshold > 0 {
+		m.slowThreshold = opts.SlowThreshold
+		m.slow = metrics.Register(registerer, prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: SlowRequestsName,
+				Help: "Number of incoming HTTP requests that took longer than the configured threshold, partitioned by method and HTTP path (grouped by patterns).",
+			},
+			[]string{"method", "uri"},
+		))
+	}
+
+	if opts.Histogram {
+		buckets := opts.Buckets
+		if len(buckets) == 0 {
+			buckets = prometheus.DefBuckets
+		}
+		m.histogram = metrics.Register(registerer, prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    RequestHistogramName,
+				Help:    "How long it took to process requests, partitioned by status code, method and HTTP path (grouped by patterns).",
+				Buckets: buckets,
+			},
+			labelNames,
+		))
+		return m
+	}
+
+	m.reqs = metrics.Register(registerer, prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: RequestCounterName,
 			Help: "Number of incoming HTTP requests processed, partitioned by status code, method and HTTP path (grouped by patterns).",
 		},
-		[]string{"method", "outcome", "status", "uri"},
-	)
-	prometheus.MustRegister(reqs)
-
-	latency = prometheus.NewSummaryVec(
+		labelNames,
+	))
+	m.latency = metrics.Register(registerer, prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
 			Name: RequestDurationName,
 			Help: "How long it took to process requests, partitioned by status code, method and HTTP path (grouped by patterns).",
 		},
-		[]string{"method", "outcome", "status", "uri"},
-	)
-	prometheus.MustRegister(latency)
+		labelNames,
+	))
+	return m
+}
+
+// Setup registers the request metrics on metrics.Registerer for use by RecordRequestMetrics.
+func Setup(options ...Options) {
+	defaultMetrics = New(nil, options...)
 }
 
+// RecordRequestMetrics is the middleware for the metrics registered by Setup().
 func RecordRequestMetrics(next http.Handler) http.Handler {
+	return defaultMetrics.Middleware(next)
+}
+
+func (m *RequestMetrics) Middleware(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ctx := apierrors.RecordErrorType(r.Context())
+		r = r.WithContext(ctx)
+
+		var body *countingReader
+		if r.Body != nil {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+
+		active := m.active.WithLabelValues(r.Method)
+		active.Inc()
+		defer active.Dec()
+
 		next.ServeHTTP(ww, r)
 
 		rctx := chi.RouteContext(r.Context())
 		routePattern := strings.Join(rctx.RoutePatterns, "")
 		routePattern = strings.Replace(routePattern, "/*/", "/", -1)
 
-		reqs.WithLabelValues(r.Method, outcome(ww.Status()), fmt.Sprintf("%d", ww.Status()), routePattern).Inc()
-		latency.WithLabelValues(r.Method, outcome(ww.Status()), fmt.Sprintf("%d", ww.Status()), routePattern).Observe(float64(time.Since(start).Microseconds()) / 1000000)
+		uri, collapsed := m.uris.uri(routePattern, ww.Status())
+		if collapsed {
+			m.collapsed.WithLabelValues(uri).Inc()
+		}
+
+		elapsed := time.Since(start)
+		duration := float64(elapsed.Microseconds()) / 1000000
+		labelValues := []string{r.Method, outcome(ww.Status()), fmt.Sprintf("%d", ww.Status()), uri, apierrors.RecordedErrorType(ctx)}
+
+		if m.histogram != nil {
+			metrics.ObserveWithTrace(r.Context(), m.histogram.WithLabelValues(labelValues...), duration)
+		} else {
+			m.reqs.WithLabelValues(labelValues...).Inc()
+			m.latency.WithLabelValues(labelValues...).Observe(duration)
+		}
+		m.max.Observe(duration, labelValues...)
+
+		m.requestBytes.WithLabelValues(r.Method, uri).Observe(float64(requestSize(r, body)))
+		m.responseBytes.WithLabelValues(r.Method, uri).Observe(float64(ww.BytesWritten()))
+		if m.slow != nil && elapsed > m.slowThreshold {
+			m.slow.WithLabelValues(r.Method, uri).Inc()
+		}
 	}
 	return http.HandlerFunc(fn)
 }
@@ -63,3 +252,25 @@ func outcome(status int) string {
 		return "SERVER_ERROR"
 	}
 }
+
+// requestSize is the content length if known, otherwise the number of bytes the handler read
+func requestSize(r *http.Request, body *countingReader) int64 {
+	if r.ContentLength >= 0 {
+		return r.ContentLength
+	}
+	if body == nil {
+		return 0
+	}
+	return body.count
+}
+
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,209 @@
+package requestmetrics
+
+import (
+	"github.com/StephanHCB/go-backend-service-common/api/apierrors"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestMetrics_Histogram(t *testing.T) {
+	docs.Description("in histogram mode, request durations are recorded in buckets, with the error type as exception label")
+
+	registry := prometheus.NewRegistry()
+	router := tstRouter(New(registry, Options{Histogram: true, Buckets: []float64{0.5, 1}}))
+
+	tstPerform(router, "/things/17")
+	tstPerform(router, "/things/unknown")
+
+	counts := tstHistogramCounts(t, registry)
+	require.Equal(t, map[string]uint64{
+		"GET 204 /things/{id} None":          1,
+		"GET 404 /things/{id} NotFoundError": 1,
+	}, counts)
+	require.Equal(t, 2, testutil.CollectAndCount(registry, RequestMaxName))
+}
+
+func TestRequestMetrics_Exemplar(t *testing.T) {
+	docs.Description("in histogram mode, the APM trace.id of the request is attached as an exemplar")
+
+	registry := prometheus.NewRegistry()
+	router := tstRouter(New(registry, Options{Histogram: true}))
+
+	tx := apmtest.DiscardTracer.StartTransaction("GET /things/{id}", "request")
+	defer tx.End()
+	request := httptest.NewRequest(http.MethodGet, "/things/17", nil)
+	router.ServeHTTP(httptest.NewRecorder(), request.WithContext(apm.ContextWithTransaction(request.Context(), tx)))
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() == RequestHistogramName {
+			exemplar := family.Metric[0].Histogram.Bucket[0].Exemplar
+			require.NotNil(t, exemplar)
+			require.Equal(t, "trace_id", exemplar.Label[0].GetName())
+			require.Equal(t, tx.TraceContext().Trace.String(), exemplar.Label[0].GetValue())
+			return
+		}
+	}
+	t.Fatal("histogram not found")
+}
+
+func TestRequestMetrics_Max(t *testing.T) {
+	docs.Description("the max gauge reports the largest duration in the window, and decays afterwards")
+
+	now := time.Now()
+	cut := newMaxCollector("test_max", "test", []string{"uri"}, 3*time.Minute)
+	cut.now = func() time.Time { return now }
+
+	cut.Observe(2.5, "/a")
+	cut.Observe(1.5, "/a")
+	require.Equal(t, 2.5, testutil.ToFloat64(cut))
+
+	now = now.Add(90 * time.Second)
+	cut.Observe(1.0, "/a")
+	require.Equal(t, 2.5, testutil.ToFloat64(cut))
+
+	now = now.Add(90 * time.Second)
+	require.Equal(t, 1.0, testutil.ToFloat64(cut))
+
+	now = now.Add(5 * time.Minute)
+	require.Equal(t, 0.0, testutil.ToFloat64(cut))
+}
+
+func TestRequestMetrics_UriCardinality(t *testing.T) {
+	docs.Description("unmatched routes and route patterns beyond the cap are collapsed in the uri label")
+
+	registry := prometheus.NewRegistry()
+	router := tstRouter(New(registry, Options{Histogram: true, Buckets: []float64{0.5, 1}, MaxUris: 1}))
+	router.Get("/other", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tstPerform(router, "/things/17")
+	tstPerform(router, "/other")
+	tstPerform(router, "/wp-admin/install.php")
+	tstPerform(router, "/things/18")
+
+	require.Equal(t, map[string]uint64{
+		"GET 204 /things/{id} None": 2,
+		"GET 204 OTHER None":        1,
+		"GET 404 NOT_FOUND None":    1,
+	}, tstHistogramCounts(t, registry))
+
+	collapsed, err := registry.Gather()
+	require.Nil(t, err)
+	for _, family := range collapsed {
+		if family.GetName() == UriCollapsedName {
+			require.Len(t, family.Metric, 2)
+		}
+	}
+}
+
+func TestRequestMetrics_UriAllowlist(t *testing.T) {
+	docs.Description("only allowed route patterns appear in the uri label")
+
+	cut := newUriLimiter([]string{"/things/{id}"}, 0)
+
+	uri, collapsed := cut.uri("/things/{id}", http.StatusOK)
+	require.Equal(t, "/things/{id}", uri)
+	require.False(t, collapsed)
+
+	uri, collapsed = cut.uri("/secret/{id}", http.StatusOK)
+	require.Equal(t, UriOther, uri)
+	require.True(t, collapsed)
+
+	uri, _ = cut.uri("", http.StatusFound)
+	require.Equal(t, UriRedirection, uri)
+
+	uri, _ = cut.uri("", http.StatusMethodNotAllowed)
+	require.Equal(t, UriUnknown, uri)
+}
+
+func TestRequestMetrics_LoadAndSizes(t *testing.T) {
+	docs.Description("active requests, request and response sizes and slow requests are recorded")
+
+	registry := prometheus.NewRegistry()
+	cut := New(registry, Options{SlowThreshold: 20 * time.Millisecond})
+	router := tstRouter(cut)
+	router.Post("/things", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, float64(1), testutil.ToFloat64(cut.active.WithLabelValues(http.MethodPost)))
+		_, _ = io.ReadAll(r.Body)
+		time.Sleep(30 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"id":"17"}`))
+	})
+
+	request := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(`{"name":"something"}`))
+	request.ContentLength = -1
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	tstPerform(router, "/things/17")
+
+	require.Equal(t, float64(0), testutil.ToFloat64(cut.active.WithLabelValues(http.MethodPost)))
+	require.Equal(t, float64(1), testutil.ToFloat64(cut.slow.WithLabelValues(http.MethodPost, "/things")))
+	require.Equal(t, 1, testutil.CollectAndCount(cut.slow))
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	sums := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			if metric.Histogram != nil && metric.Label[0].GetValue() == http.MethodPost {
+				sums[family.GetName()] = metric.Histogram.GetSampleSum()
+			}
+		}
+	}
+	require.Equal(t, float64(20), sums[RequestBytesName])
+	require.Equal(t, float64(11), sums[ResponseBytesName])
+}
+
+// --- helpers ---
+
+func tstRouter(cut *RequestMetrics) chi.Router {
+	router := chi.NewRouter()
+	router.Use(cut.Middleware)
+	router.Get("/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "unknown" {
+			apierrors.HandleError(r.Context(), w, r, apierrors.NewNotFoundError("thing.notfound", "", nil, time.Now()), apierrors.IsNotFoundError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return router
+}
+
+func tstPerform(router chi.Router, path string) {
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+}
+
+// tstHistogramCounts returns the observation counts by "method status uri exception"
+func tstHistogramCounts(t *testing.T, registry *prometheus.Registry) map[string]uint64 {
+	families, err := registry.Gather()
+	require.Nil(t, err)
+
+	result := make(map[string]uint64)
+	for _, family := range families {
+		if family.GetName() != RequestHistogramName {
+			continue
+		}
+		for _, metric := range family.Metric {
+			labels := make(map[string]string)
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			require.Len(t, metric.Histogram.Bucket, 2)
+			key := labels["method"] + " " + labels["status"] + " " + labels["uri"] + " " + labels["exception"]
+			result[key] = metric.Histogram.GetSampleCount()
+		}
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package requestmetrics
+
+import (
+	"net/http"
+	"sync"
+)
+
+// uri label values for requests that did not match a route, or whose route was collapsed, same as in Spring
+const (
+	UriNotFound    = "NOT_FOUND"
+	UriRedirection = "REDIRECTION"
+	UriUnknown     = "UNKNOWN"
+	// UriOther is used for route patterns that are not in the allowlist, or exceed the cap
+	UriOther = "OTHER"
+)
+
+// uriLimiter keeps the number of distinct uri label values under control.
+type uriLimiter struct {
+	allowed map[string]bool
+	max     int
+
+	lock sync.Mutex
+	seen map[string]bool
+}
+
+func newUriLimiter(allowed []string, max int) *uriLimiter {
+	limiter := &uriLimiter{
+		max:  max,
+		seen: make(map[string]bool),
+	}
+	if len(allowed) > 0 {
+		limiter.allowed = make(map[string]bool)
+		for _, uri := range allowed {
+			limiter.allowed[uri] = true
+		}
+	}
+	return limiter
+}
+
+// uri returns the label value for a route pattern, and whether it was collapsed
+func (l *uriLimiter) uri(routePattern string, status int) (string, bool) {
+	if routePattern == "" {
+		if status == http.StatusNotFound {
+			return UriNotFound, true
+		} else if status >= 300 && status < 400 {
+			return UriRedirection, true
+		}
+		return UriUnknown, true
+	}
+
+	if l.allowed != nil && !l.allowed[routePattern] {
+		return UriOther, true
+	}
+
+	if l.max > 0 {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		if !l.seen[routePattern] {
+			if len(l.seen) >= l.max {
+				return UriOther, true
+			}
+			l.seen[routePattern] = true
+		}
+	}
+
+	return routePattern, false
+}
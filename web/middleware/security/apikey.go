@@ -0,0 +1,212 @@
+package security
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultApiKeyHeader = "X-API-Key"
+
+// ApiKeyStore looks up api keys by their hash, see HashApiKey.
+//
+// Looking up by hash means the stored keys are never compared to the presented key, so there is no timing leak.
+type ApiKeyStore interface {
+	// Lookup returns the api key with the given hash, or nil if there is none.
+	Lookup(ctx context.Context, keyHash string) (*repository.ApiKey, error)
+}
+
+type ApiKeyMiddlewareOptions struct {
+	// Header is the request header containing the api key, defaults to DefaultApiKeyHeader
+	Header string
+	Store  ApiKeyStore
+}
+
+// HashApiKey returns the hex encoded sha256 hash of an api key, as expected by the stores.
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func ApiKeyValidatorMiddleware(options ApiKeyMiddlewareOptions) func(http.Handler) http.Handler {
+	header := options.Header
+	if header == "" {
+		header = DefaultApiKeyHeader
+	}
+	mw := func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := strings.TrimSpace(r.Header.Get(header))
+			if key == "" {
+				// valid case, no api key provided, fall through
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			apiKey, err := options.Store.Lookup(ctx, HashApiKey(key))
+			if err != nil {
+				unauthorizedErrorHandler(ctx, w, r, fmt.Sprintf("api key lookup failed: %s", err.Error()), Now())
+				return
+			}
+			if apiKey == nil {
+				unauthorizedErrorHandler(ctx, w, r, "unknown api key", Now())
+				return
+			}
+
+			specifiedClaims := AllClaims{
+				RegisteredClaims: jwt.RegisteredClaims{},
+				CustomClaims: CustomClaims{
+					Name:   apiKey.Name,
+					Email:  apiKey.Email,
+					Groups: apiKey.Groups,
+					Roles:  apiKey.Roles,
+					Scopes: apiKey.Scopes,
+				},
+			}
+			ctx = PutClaims(ctx, &specifiedClaims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+	return mw
+}
+
+// ConfigApiKeyStore holds a fixed list of api keys, typically from Configuration.ApiKeys().
+type ConfigApiKeyStore struct {
+	keys map[string]repository.ApiKey
+}
+
+func NewConfigApiKeyStore(apiKeys []repository.ApiKey) (*ConfigApiKeyStore, error) {
+	keys := make(map[string]repository.ApiKey)
+	for _, apiKey := range apiKeys {
+		if len(apiKey.KeyHash) != sha256.Size*2 {
+			return nil, fmt.Errorf("api key '%s' must have a keyHash of 64 hex digits", apiKey.Name)
+		}
+		if _, err := hex.DecodeString(apiKey.KeyHash); err != nil {
+			return nil, fmt.Errorf("api key '%s' must have a keyHash of 64 hex digits", apiKey.Name)
+		}
+		keyHash := strings.ToLower(apiKey.KeyHash)
+		if _, ok := keys[keyHash]; ok {
+			return nil, fmt.Errorf("api key '%s' has the same keyHash as another api key", apiKey.Name)
+		}
+		keys[keyHash] = apiKey
+	}
+	return &ConfigApiKeyStore{keys: keys}, nil
+}
+
+func (s *ConfigApiKeyStore) Lookup(_ context.Context, keyHash string) (*repository.ApiKey, error) {
+	apiKey, ok := s.keys[keyHash]
+	if !ok {
+		return nil, nil
+	}
+	return &apiKey, nil
+}
+
+type VaultApiKeyStoreOptions struct {
+	Vault repository.Vault
+	// Path is the vault path holding the api keys. Each secret at the path is the json of a repository.ApiKey,
+	// the secret names do not matter, e.g. use the client name.
+	Path string
+	// RefreshInterval is how often the keys are fetched again, so keys can be added or revoked without a restart.
+	// Defaults to 5 minutes.
+	RefreshInterval time.Duration
+	// RefreshTimeout limits how long a refresh may take, default 10 seconds.
+	//
+	// Refreshes are not cancelled when the request that triggered them is.
+	RefreshTimeout time.Duration
+}
+
+// VaultApiKeyStore reads the api keys from a vault path, and refreshes them periodically.
+type VaultApiKeyStore struct {
+	options VaultApiKeyStoreOptions
+
+	mu          sync.RWMutex
+	keys        *ConfigApiKeyStore
+	lastRefresh time.Time
+
+	refreshLock sync.Mutex
+}
+
+// NewVaultApiKeyStore fetches the keys initially, and fails if that does not work, because
+// the service could not authenticate any api key clients.
+//
+// The vault must already be authenticated, so use this after vault.Execute.
+func NewVaultApiKeyStore(ctx context.Context, options VaultApiKeyStoreOptions) (*VaultApiKeyStore, error) {
+	if options.Vault == nil || options.Path == "" {
+		return nil, fmt.Errorf("vault api key store needs a vault and a path")
+	}
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = 5 * time.Minute
+	}
+	if options.RefreshTimeout <= 0 {
+		options.RefreshTimeout = 10 * time.Second
+	}
+	store := &VaultApiKeyStore{options: options}
+	if err := store.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Lookup refreshes the keys if the refresh interval has passed. If refreshing fails,
+// the previous keys continue to be used, and the refresh is retried on the next interval.
+func (s *VaultApiKeyStore) Lookup(ctx context.Context, keyHash string) (*repository.ApiKey, error) {
+	s.mu.RLock()
+	stale := Now().Sub(s.lastRefresh) >= s.options.RefreshInterval
+	s.mu.RUnlock()
+
+	if stale && s.refreshLock.TryLock() {
+		refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.options.RefreshTimeout)
+		err := s.refresh(refreshCtx)
+		cancel()
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to refresh api keys from vault path %s, continuing with previous keys: %s", s.options.Path, err.Error())
+			s.mu.Lock()
+			s.lastRefresh = Now()
+			s.mu.Unlock()
+		}
+		s.refreshLock.Unlock()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys.Lookup(ctx, keyHash)
+}
+
+func (s *VaultApiKeyStore) refresh(ctx context.Context) error {
+	secrets, err := s.options.Vault.ObtainSecretsAt(ctx, s.options.Path)
+	if err != nil {
+		return err
+	}
+
+	apiKeys := make([]repository.ApiKey, 0, len(secrets))
+	for name, secret := range secrets {
+		apiKey := repository.ApiKey{}
+		if err := json.Unmarshal([]byte(secret), &apiKey); err != nil {
+			return fmt.Errorf("api key '%s' at vault path %s is not valid json: %s", name, s.options.Path, err.Error())
+		}
+		if apiKey.Name == "" {
+			apiKey.Name = name
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	keys, err := NewConfigApiKeyStore(apiKeys)
+	if err != nil {
+		return fmt.Errorf("api keys at vault path %s are invalid: %w", s.options.Path, err)
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.lastRefresh = Now()
+	s.mu.Unlock()
+	return nil
+}
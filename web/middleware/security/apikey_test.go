@@ -0,0 +1,167 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApiKeyValidatorMiddleware(t *testing.T) {
+	docs.Description("the api key middleware puts the claims of a known key into the context, and lets requests without key through")
+
+	store, err := NewConfigApiKeyStore([]repository.ApiKey{
+		{KeyHash: HashApiKey("billing-key"), Name: "billing", Groups: []string{"billing-clients"}, Scopes: []string{"invoices:read"}},
+	})
+	require.Nil(t, err)
+	cut := ApiKeyValidatorMiddleware(ApiKeyMiddlewareOptions{Header: "X-Custom-Key", Store: store})
+
+	testcases := []struct {
+		name     string
+		key      string
+		status   int
+		expected *CustomClaims
+	}{
+		{"known key", "billing-key", http.StatusNoContent, &CustomClaims{Name: "billing", Groups: []string{"billing-clients"}, Scopes: []string{"invoices:read"}}},
+		{"unknown key", "other-key", http.StatusUnauthorized, nil},
+		{"no key", "", http.StatusNoContent, nil},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, claims := tstApiKeyRequest(cut, "X-Custom-Key", tc.key)
+			require.Equal(t, tc.status, response.Code)
+			if tc.expected == nil {
+				require.Nil(t, claims)
+			} else {
+				require.Equal(t, *tc.expected, claims.CustomClaims)
+				require.Nil(t, HasGroup(claims.ctx, "billing-clients", "", time.Now()))
+			}
+		})
+	}
+}
+
+func TestConfigApiKeyStore_Invalid(t *testing.T) {
+	docs.Description("api keys with an invalid or duplicate hash are refused")
+
+	_, err := NewConfigApiKeyStore([]repository.ApiKey{{KeyHash: "not-a-hash"}})
+	require.NotNil(t, err)
+	_, err = NewConfigApiKeyStore([]repository.ApiKey{{KeyHash: HashApiKey("a")}, {KeyHash: HashApiKey("a")}})
+	require.NotNil(t, err)
+}
+
+func TestVaultApiKeyStore_Refresh(t *testing.T) {
+	docs.Description("the vault api key store picks up added and revoked keys, and keeps the previous keys if vault fails")
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer tstSetNow(&now)()
+
+	vault := &tstVault{secrets: map[string]string{
+		"billing": `{"keyHash": "` + HashApiKey("billing-key") + `", "groups": ["billing-clients"]}`,
+	}}
+	store, err := NewVaultApiKeyStore(context.Background(), VaultApiKeyStoreOptions{Vault: vault, Path: "service/apikeys", RefreshInterval: time.Minute})
+	require.Nil(t, err)
+	cut := ApiKeyValidatorMiddleware(ApiKeyMiddlewareOptions{Store: store})
+
+	response, claims := tstApiKeyRequest(cut, DefaultApiKeyHeader, "billing-key")
+	require.Equal(t, http.StatusNoContent, response.Code)
+	require.Equal(t, "billing", claims.Name)
+	require.Equal(t, []string{"billing-clients"}, claims.Groups)
+
+	// rotate, not yet visible
+	vault.secrets = map[string]string{"reporting": `{"keyHash": "` + HashApiKey("reporting-key") + `"}`}
+	response, _ = tstApiKeyRequest(cut, DefaultApiKeyHeader, "billing-key")
+	require.Equal(t, http.StatusNoContent, response.Code)
+
+	now = now.Add(time.Minute)
+	response, _ = tstApiKeyRequest(cut, DefaultApiKeyHeader, "billing-key")
+	require.Equal(t, http.StatusUnauthorized, response.Code)
+	response, _ = tstApiKeyRequest(cut, DefaultApiKeyHeader, "reporting-key")
+	require.Equal(t, http.StatusNoContent, response.Code)
+
+	vault.err = errors.New("vault is down")
+	now = now.Add(time.Minute)
+	response, _ = tstApiKeyRequest(cut, DefaultApiKeyHeader, "reporting-key")
+	require.Equal(t, http.StatusNoContent, response.Code)
+	require.Equal(t, 3, vault.fetches)
+
+	_, err = NewVaultApiKeyStore(context.Background(), VaultApiKeyStoreOptions{Vault: vault, Path: "service/apikeys"})
+	require.NotNil(t, err)
+}
+
+func TestVaultApiKeyStore_RefreshOutlivesRequest(t *testing.T) {
+	docs.Description("a refresh of the vault api key store is not cancelled with the request that triggered it")
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer tstSetNow(&now)()
+
+	vault := &tstVault{secrets: map[string]string{"billing": `{"keyHash": "` + HashApiKey("billing-key") + `"}`}}
+	store, err := NewVaultApiKeyStore(context.Background(), VaultApiKeyStoreOptions{Vault: vault, Path: "service/apikeys", RefreshInterval: time.Minute})
+	require.Nil(t, err)
+
+	vault.secrets = map[string]string{"reporting": `{"keyHash": "` + HashApiKey("reporting-key") + `"}`}
+	now = now.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	apiKey, err := store.Lookup(ctx, HashApiKey("reporting-key"))
+	require.Nil(t, err)
+	require.NotNil(t, apiKey)
+	require.Equal(t, "reporting", apiKey.Name)
+}
+
+// --- helpers ---
+
+type tstApiKeyClaims struct {
+	*AllClaims
+	ctx context.Context
+}
+
+func tstApiKeyRequest(cut func(http.Handler) http.Handler, header string, key string) (*httptest.ResponseRecorder, *tstApiKeyClaims) {
+	var claims *tstApiKeyClaims
+	r := httptest.NewRequest(http.MethodGet, "/v1/api", nil)
+	if key != "" {
+		r.Header.Set(header, key)
+	}
+	w := httptest.NewRecorder()
+	cut(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allClaims := GetClaims(r.Context()); allClaims != nil {
+			claims = &tstApiKeyClaims{AllClaims: allClaims, ctx: r.Context()}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(w, r)
+	return w, claims
+}
+
+type tstVault struct {
+	secrets map[string]string
+	err     error
+	fetches int
+}
+
+func (v *tstVault) IsVault() bool {
+	return true
+}
+
+func (v *tstVault) Setup(_ context.Context) error {
+	return nil
+}
+
+func (v *tstVault) Authenticate(_ context.Context) error {
+	return nil
+}
+
+func (v *tstVault) ObtainSecrets(_ context.Context) error {
+	return nil
+}
+
+func (v *tstVault) ObtainSecretsAt(ctx context.Context, _ string) (map[string]string, error) {
+	v.fetches++
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return v.secrets, v.err
+}
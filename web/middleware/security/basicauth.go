@@ -1,12 +1,18 @@
 package security
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/subtle"
+	"fmt"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
 	"github.com/go-http-utils/headers"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 )
 
 type BasicAuthMiddlewareOptions struct {
@@ -18,9 +24,41 @@ type BasicAuthMiddlewareOptions struct {
 	BasicAuthUsername string
 	BasicAuthPassword string
 	BasicAuthClaims   CustomClaims
+
+	// Users are additional basic auth users with bcrypt or argon2id password hashes, each with their
+	// own claims, typically from Configuration.BasicAuthUsers(). Check them with ValidateBasicAuthUsers.
+	Users []repository.BasicAuthUser
+
+	// MaxConcurrentHashVerifications limits how many password hashes are verified at the same time, default
+	// the number of CPUs. Verifying a hash is deliberately expensive in CPU and memory, so without a limit,
+	// a flood of basic auth requests could exhaust them. Further requests wait for their turn.
+	MaxConcurrentHashVerifications int
+	// VerifiedCacheTtl is how long a successful verification is remembered, default 1 minute, so clients
+	// sending the same credentials with every request do not need a hash verification each time.
+	VerifiedCacheTtl time.Duration
+}
+
+// maxVerifiedCacheEntries limits the memory used for remembering successful verifications
+const maxVerifiedCacheEntries = 1000
+
+// ValidateBasicAuthUsers checks usernames and password hashes, so configuration errors show up at startup.
+func ValidateBasicAuthUsers(users []repository.BasicAuthUser) error {
+	for i, user := range users {
+		if user.Username == "" {
+			return fmt.Errorf("basic auth user #%d has no username", i+1)
+		}
+		if user.PasswordHash == "" {
+			return fmt.Errorf("basic auth user '%s' has no password hash", user.Username)
+		}
+		if err := ValidatePasswordHash(user.PasswordHash); err != nil {
+			return fmt.Errorf("basic auth user '%s' has an invalid password hash: %w", user.Username, err)
+		}
+	}
+	return nil
 }
 
 func BasicAuthValidatorMiddleware(options BasicAuthMiddlewareOptions) func(http.Handler) http.Handler {
+	checker := newBasicAuthChecker(options)
 	mw := func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			authHeaderValue := r.Header.Get(headers.Authorization)
@@ -32,10 +70,10 @@ func BasicAuthValidatorMiddleware(options BasicAuthMiddlewareOptions) func(http.
 				ctx := r.Context()
 				username, password, basicAuthOk := r.BasicAuth()
 				if basicAuthOk {
-					if checkBasicAuthValue(username, password, options) {
+					if claims, ok := checker.check(ctx, username, password); ok {
 						specifiedClaims := AllClaims{
 							RegisteredClaims: jwt.RegisteredClaims{},
-							CustomClaims:     options.BasicAuthClaims,
+							CustomClaims:     claims,
 						}
 						ctx = PutClaims(ctx, &specifiedClaims)
 						next.ServeHTTP(w, r.WithContext(ctx))
@@ -54,6 +92,122 @@ func BasicAuthValidatorMiddleware(options BasicAuthMiddlewareOptions) func(http.
 	return mw
 }
 
+type basicAuthChecker struct {
+	options BasicAuthMiddlewareOptions
+
+	// one token per hash verification that may run
+	verifications chan struct{}
+
+	lock     sync.Mutex
+	verified map[[sha256.Size]byte]verifiedBasicAuth
+}
+
+type verifiedBasicAuth struct {
+	claims  CustomClaims
+	expires time.Time
+}
+
+func newBasicAuthChecker(options BasicAuthMiddlewareOptions) *basicAuthChecker {
+	if options.MaxConcurrentHashVerifications <= 0 {
+		options.MaxConcurrentHashVerifications = runtime.NumCPU()
+	}
+	if options.VerifiedCacheTtl <= 0 {
+		options.VerifiedCacheTtl = time.Minute
+	}
+	return &basicAuthChecker{
+		options:       options,
+		verifications: make(chan struct{}, options.MaxConcurrentHashVerifications),
+		verified:      make(map[[sha256.Size]byte]verifiedBasicAuth),
+	}
+}
+
+// check returns the claims of the matching user.
+//
+// All usernames are compared, and exactly one password hash is verified, even for unknown users,
+// so the response time does not reveal which usernames exist.
+func (c *basicAuthChecker) check(ctx context.Context, username string, password string) (CustomClaims, bool) {
+	options := c.options
+	if username == "" || password == "" {
+		return CustomClaims{}, false
+	}
+
+	if options.BasicAuthUsername != "" && checkBasicAuthValue(username, password, options) {
+		return options.BasicAuthClaims, true
+	}
+	if len(options.Users) == 0 {
+		return CustomClaims{}, false
+	}
+
+	key := sha256.Sum256([]byte(username + ":" + password))
+	if claims, ok := c.cached(key); ok {
+		return claims, true
+	}
+
+	usernameHash := sha256.Sum256([]byte(username))
+	matched := 0
+	for i := range options.Users {
+		expectedUsernameHash := sha256.Sum256([]byte(options.Users[i].Username))
+		isMatch := subtle.ConstantTimeCompare(expectedUsernameHash[:], usernameHash[:])
+		matched = subtle.ConstantTimeSelect(isMatch, i, matched)
+	}
+	// for unknown usernames this verifies against the first user's hash, and then fails on the username
+	user := options.Users[matched]
+	passwordMatch, err := c.verify(ctx, user.PasswordHash, password)
+	if err != nil || !passwordMatch || user.Username != username {
+		return CustomClaims{}, false
+	}
+
+	claims := CustomClaims{
+		Name:   user.Name,
+		Email:  user.Email,
+		Groups: user.Groups,
+		Roles:  user.Roles,
+		Scopes: user.Scopes,
+	}
+	c.remember(key, claims)
+	return claims, true
+}
+
+// verify waits until fewer than MaxConcurrentHashVerifications are running, or the request is cancelled
+func (c *basicAuthChecker) verify(ctx context.Context, passwordHash string, password string) (bool, error) {
+	select {
+	case c.verifications <- struct{}{}:
+		defer func() { <-c.verifications }()
+		return VerifyPasswordHash(passwordHash, password), nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (c *basicAuthChecker) cached(key [sha256.Size]byte) (CustomClaims, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.verified[key]
+	if !ok || !Now().Before(entry.expires) {
+		return CustomClaims{}, false
+	}
+	return entry.claims, true
+}
+
+func (c *basicAuthChecker) remember(key [sha256.Size]byte, claims CustomClaims) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := Now()
+	if len(c.verified) >= maxVerifiedCacheEntries {
+		for k, v := range c.verified {
+			if !now.Before(v.expires) {
+				delete(c.verified, k)
+			}
+		}
+		if len(c.verified) >= maxVerifiedCacheEntries {
+			return
+		}
+	}
+	c.verified[key] = verifiedBasicAuth{claims: claims, expires: now.Add(c.options.VerifiedCacheTtl)}
+}
+
 func checkBasicAuthValue(username string, password string, options BasicAuthMiddlewareOptions) bool {
 	if username == "" || password == "" {
 		return false
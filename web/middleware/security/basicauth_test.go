@@ -1,12 +1,19 @@
 package security
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
 	"github.com/StephanHCB/go-backend-service-common/docs"
 	"github.com/go-http-utils/headers"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 const noAuth = ""
@@ -40,6 +47,96 @@ func TestBasicAuthValidatorMiddleware_OtherAuth_FallThrough(t *testing.T) {
 	tstBasicAuthTestcase(t, otherAuth, true, false)
 }
 
+func TestBasicAuthValidatorMiddleware_HashedUsers(t *testing.T) {
+	docs.Description("basic auth middleware supports several users with bcrypt or argon2id password hashes, each with their own claims")
+
+	users := []repository.BasicAuthUser{
+		{Username: "ci", PasswordHash: tstBcryptHash(t, "ci-secret"), Name: "CI", Groups: []string{"deployers"}},
+		{Username: "monitor", PasswordHash: tstArgon2idHash("monitor-secret"), Roles: []string{"reader"}, Scopes: []string{"health:read"}},
+	}
+	require.Nil(t, ValidateBasicAuthUsers(users))
+	cut := BasicAuthValidatorMiddleware(BasicAuthMiddlewareOptions{Users: users})
+
+	testcases := []struct {
+		name     string
+		username string
+		password string
+		expected *CustomClaims
+	}{
+		{"bcrypt", "ci", "ci-secret", &CustomClaims{Name: "CI", Groups: []string{"deployers"}}},
+		{"argon2id", "monitor", "monitor-secret", &CustomClaims{Roles: []string{"reader"}, Scopes: []string{"health:read"}}},
+		{"wrong password", "ci", "monitor-secret", nil},
+		{"other user's password", "monitor", "ci-secret", nil},
+		{"unknown user", "nobody", "ci-secret", nil},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var claims *AllClaims
+			r := httptest.NewRequest(http.MethodGet, "/v1/api", nil)
+			r.SetBasicAuth(tc.username, tc.password)
+			w := httptest.NewRecorder()
+			cut(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims = GetClaims(r.Context())
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+
+			if tc.expected == nil {
+				require.Equal(t, http.StatusUnauthorized, w.Code)
+			} else {
+				require.Equal(t, http.StatusNoContent, w.Code)
+				require.Equal(t, *tc.expected, claims.CustomClaims)
+			}
+		})
+	}
+}
+
+func TestBasicAuthChecker_LimitsHashVerifications(t *testing.T) {
+	docs.Description("password hash verifications are limited in number, and successful ones are remembered for a while")
+
+	now := time.Now()
+	defer tstSetNow(&now)()
+
+	cut := newBasicAuthChecker(BasicAuthMiddlewareOptions{
+		Users:                          []repository.BasicAuthUser{{Username: "ci", PasswordHash: tstBcryptHash(t, "ci-secret"), Name: "CI"}},
+		MaxConcurrentHashVerifications: 1,
+	})
+
+	// another verification is running, and the request gives up waiting
+	cut.verifications <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, ok := cut.check(ctx, "ci", "ci-secret")
+	require.False(t, ok)
+	<-cut.verifications
+
+	claims, ok := cut.check(context.Background(), "ci", "ci-secret")
+	require.True(t, ok)
+	require.Equal(t, "CI", claims.Name)
+
+	// remembered, so no verification needed
+	cut.verifications <- struct{}{}
+	_, ok = cut.check(ctx, "ci", "ci-secret")
+	require.True(t, ok)
+	_, ok = cut.check(ctx, "ci", "wrong-secret")
+	require.False(t, ok)
+	<-cut.verifications
+
+	cut.options.Users[0].PasswordHash = tstBcryptHash(t, "new-secret")
+	now = now.Add(2 * time.Minute)
+	_, ok = cut.check(context.Background(), "ci", "ci-secret")
+	require.False(t, ok)
+}
+
+func TestValidateBasicAuthUsers_InvalidHashes(t *testing.T) {
+	docs.Description("invalid basic auth users are reported by ValidateBasicAuthUsers")
+
+	for _, hash := range []string{"", "plaintext", "$2a$10$short", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$aGFzaA", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA"} {
+		require.NotNil(t, ValidateBasicAuthUsers([]repository.BasicAuthUser{{Username: "user", PasswordHash: hash}}), hash)
+	}
+	require.NotNil(t, ValidateBasicAuthUsers([]repository.BasicAuthUser{{PasswordHash: tstArgon2idHash("pw")}}))
+	require.False(t, VerifyPasswordHash("plaintext", "plaintext"))
+}
+
 // --- helpers ---
 
 func tstBasicAuthTestcase(t *testing.T, authorization string, shouldGoThrough bool, shouldAuthorize bool) {
@@ -96,3 +193,16 @@ func tstConstructBasicAuthHandlerUnderTest(t *testing.T, expectAuthorized bool,
 
 	return middlewareUnderTest(verifyingHandler)
 }
+
+func tstBcryptHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.Nil(t, err)
+	return string(hash)
+}
+
+func tstArgon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
@@ -0,0 +1,215 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ClaimMapping configures where the normalized claims in CustomClaims are taken from.
+//
+// Each entry is a JSON path into the token claims, such as "realm_access.roles", "$.resource_access.my-client.roles"
+// or `resource_access["my.client"].roles`. A "*" segment matches all keys of an object, e.g. "resource_access.*.roles".
+//
+// Name and Email use the first path that yields a value. Groups, Roles and Scopes collect the values of all
+// paths. Values may be strings or arrays of strings. Strings are split at spaces for Scopes, as in the OAuth scope claim.
+type ClaimMapping struct {
+	// Name defaults to "name"
+	Name []string
+	// Email defaults to "email"
+	Email []string
+	// Groups defaults to "groups"
+	Groups []string
+	// Roles defaults to "roles" (Azure AD). For Keycloak use "realm_access.roles" and "resource_access.<client>.roles"
+	Roles []string
+	// Scopes defaults to "scope" and "scp"
+	Scopes []string
+}
+
+var defaultClaimMapping = ClaimMapping{
+	Name:   []string{"name"},
+	Email:  []string{"email"},
+	Groups: []string{"groups"},
+	Roles:  []string{"roles"},
+	Scopes: []string{"scope", "scp"},
+}
+
+// Validate checks all paths, so configuration errors show up at startup.
+func (m ClaimMapping) Validate() error {
+	for _, paths := range [][]string{m.Name, m.Email, m.Groups, m.Roles, m.Scopes} {
+		for _, path := range paths {
+			if _, err := parseClaimPath(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Apply fills the normalized claims from claims.Raw. Invalid paths are ignored, see Validate.
+func (m ClaimMapping) Apply(claims *AllClaims) {
+	m = m.withDefaults()
+	claims.Name = firstString(claims.Raw, m.Name)
+	claims.Email = firstString(claims.Raw, m.Email)
+	claims.Groups = allStrings(claims.Raw, m.Groups, false)
+	claims.Roles = allStrings(claims.Raw, m.Roles, false)
+	claims.Scopes = allStrings(claims.Raw, m.Scopes, true)
+}
+
+func (m ClaimMapping) withDefaults() ClaimMapping {
+	if len(m.Name) == 0 {
+		m.Name = defaultClaimMapping.Name
+	}
+	if len(m.Email) == 0 {
+		m.Email = defaultClaimMapping.Email
+	}
+	if len(m.Groups) == 0 {
+		m.Groups = defaultClaimMapping.Groups
+	}
+	if len(m.Roles) == 0 {
+		m.Roles = defaultClaimMapping.Roles
+	}
+	if len(m.Scopes) == 0 {
+		m.Scopes = defaultClaimMapping.Scopes
+	}
+	return m
+}
+
+// RawClaims returns all claims of the token in the context, or nil if there is none.
+//
+// Claims set up without a token, e.g. by basic auth, have no raw claims.
+func RawClaims(ctx context.Context) map[string]interface{} {
+	claimsPtr := GetClaims(ctx)
+	if claimsPtr == nil {
+		return nil
+	}
+	return claimsPtr.Raw
+}
+
+// Claim returns the value of a claim given by a JSON path, see ClaimMapping.
+//
+// If a "*" segment matches more than one value, the first one found is returned.
+func Claim(ctx context.Context, path string) (interface{}, bool) {
+	values := lookupClaim(RawClaims(ctx), path)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+func firstString(raw map[string]interface{}, paths []string) string {
+	for _, path := range paths {
+		for _, value := range lookupClaim(raw, path) {
+			if s, ok := value.(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// allStrings returns nil if there are no values, like json decoding a missing array
+func allStrings(raw map[string]interface{}, paths []string, splitSpaces bool) []string {
+	var result []string
+	add := func(s string) {
+		if s != "" && !contains(result, s) {
+			result = append(result, s)
+		}
+	}
+	for _, path := range paths {
+		for _, value := range lookupClaim(raw, path) {
+			switch v := value.(type) {
+			case string:
+				if splitSpaces {
+					for _, part := range strings.Fields(v) {
+						add(part)
+					}
+				} else {
+					add(v)
+				}
+			case []interface{}:
+				for _, element := range v {
+					if s, ok := element.(string); ok {
+						add(s)
+					}
+				}
+			case []string:
+				for _, s := range v {
+					add(s)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func lookupClaim(raw map[string]interface{}, path string) []interface{} {
+	if raw == nil {
+		return nil
+	}
+	segments, err := parseClaimPath(path)
+	if err != nil {
+		return nil
+	}
+
+	current := []interface{}{raw}
+	for _, segment := range segments {
+		next := make([]interface{}, 0)
+		for _, value := range current {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if segment == "*" {
+				for _, child := range object {
+					next = append(next, child)
+				}
+			} else if child, ok := object[segment]; ok {
+				next = append(next, child)
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+// parseClaimPath splits a path like $.a.b["c.d"] into its segments a, b, c.d
+func parseClaimPath(path string) ([]string, error) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if rest == "" {
+		return nil, fmt.Errorf("invalid claim path '%s': empty", path)
+	}
+
+	segments := make([]string, 0)
+	for rest != "" {
+		if strings.HasPrefix(rest, "[") {
+			if len(rest) < 4 || (rest[1] != '"' && rest[1] != '\'') {
+				return nil, fmt.Errorf("invalid claim path '%s': expected quoted key after [", path)
+			}
+			end := strings.Index(rest[2:], string(rest[1])+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid claim path '%s': unterminated [", path)
+			}
+			segments = append(segments, rest[2:2+end])
+			rest = strings.TrimPrefix(rest[2+end+2:], ".")
+			continue
+		}
+
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("invalid claim path '%s': empty segment", path)
+		}
+		segments = append(segments, rest[:end])
+		rest = rest[end:]
+		if strings.HasPrefix(rest, ".") {
+			rest = rest[1:]
+			if rest == "" {
+				return nil, fmt.Errorf("invalid claim path '%s': ends with .", path)
+			}
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,102 @@
+package security
+
+import (
+	"context"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClaimMapping_Keycloak(t *testing.T) {
+	docs.Description("the claim mapping collects Keycloak realm and client roles, and splits the scope claim")
+
+	mapping := ClaimMapping{
+		Name:  []string{"name", "preferred_username"},
+		Roles: []string{"realm_access.roles", `resource_access["room.service"].roles`},
+	}
+	require.Nil(t, mapping.Validate())
+
+	ctx := tstParseWithMapping(t, mapping, jwt.MapClaims{
+		"preferred_username": "jdoe",
+		"groups":             []string{"/admins"},
+		"scope":              "openid profile rooms:write",
+		"realm_access":       map[string]interface{}{"roles": []string{"offline_access", "user"}},
+		"resource_access": map[string]interface{}{
+			"room.service": map[string]interface{}{"roles": []string{"room-admin", "user"}},
+			"other":        map[string]interface{}{"roles": []string{"other-admin"}},
+		},
+	})
+
+	require.Equal(t, "jdoe", Name(ctx))
+	require.Equal(t, []string{"/admins"}, GetClaims(ctx).Groups)
+	require.Equal(t, []string{"offline_access", "user", "room-admin"}, Roles(ctx))
+	require.Equal(t, []string{"openid", "profile", "rooms:write"}, Scopes(ctx))
+	require.Nil(t, HasRole(ctx, "room-admin", "", time.Now()))
+	require.NotNil(t, HasRole(ctx, "other-admin", "", time.Now()))
+	require.Nil(t, HasScope(ctx, "rooms:write", "", time.Now()))
+	require.NotNil(t, HasScope(ctx, "rooms:delete", "", time.Now()))
+}
+
+func TestClaimMapping_Defaults(t *testing.T) {
+	docs.Description("by default, roles are taken from the roles claim as used by Azure AD, and scopes from scp")
+
+	ctx := tstParseWithMapping(t, ClaimMapping{}, jwt.MapClaims{
+		"name":  "John Doe",
+		"email": "john.doe@example.com",
+		"roles": []string{"Task.Write"},
+		"scp":   "Task.Read",
+	})
+
+	require.Equal(t, "John Doe", Name(ctx))
+	require.Equal(t, "john.doe@example.com", Email(ctx))
+	require.Nil(t, GetClaims(ctx).Groups)
+	require.Equal(t, []string{"Task.Write"}, Roles(ctx))
+	require.Equal(t, []string{"Task.Read"}, Scopes(ctx))
+}
+
+func TestClaim_RawAccess(t *testing.T) {
+	docs.Description("all claims of the token remain available, also by JSON path and with wildcards")
+
+	ctx := tstParseWithMapping(t, ClaimMapping{Roles: []string{"resource_access.*.roles"}}, jwt.MapClaims{
+		"tenant":          "acme",
+		"resource_access": map[string]interface{}{"client": map[string]interface{}{"roles": []string{"reader"}}},
+	})
+
+	require.Equal(t, "acme", RawClaims(ctx)["tenant"])
+	value, ok := Claim(ctx, "$.resource_access.client.roles")
+	require.True(t, ok)
+	require.Equal(t, []interface{}{"reader"}, value)
+	_, ok = Claim(ctx, "resource_access.other")
+	require.False(t, ok)
+	require.Equal(t, []string{"reader"}, Roles(ctx))
+
+	require.Nil(t, RawClaims(context.Background()))
+}
+
+func TestClaimMapping_InvalidPaths(t *testing.T) {
+	docs.Description("invalid claim paths are reported by Validate")
+
+	for _, path := range []string{"", "$", "a..b", "a.", `a["b`, "a[b]"} {
+		require.NotNil(t, ClaimMapping{Groups: []string{path}}.Validate(), path)
+	}
+	segments, err := parseClaimPath(`$.a['b.c'].d["e"]`)
+	require.Nil(t, err)
+	require.Equal(t, []string{"a", "b.c", "d", "e"}, segments)
+}
+
+// --- helpers ---
+
+func tstParseWithMapping(t *testing.T, mapping ClaimMapping, claims jwt.MapClaims) context.Context {
+	idp := tstNewIdp(t, "key1")
+	keySet, err := NewJwksKeySet(context.Background(), JwksKeySetOptions{JwksUrl: idp.server.URL + "/certs"})
+	require.Nil(t, err)
+
+	claims["exp"] = Now().Add(time.Hour).Unix()
+	tokenString := strings.TrimPrefix(idp.tokenWithClaims(t, "key1", "key1", claims), "Bearer ")
+	token, reason, message := parseJwt(context.Background(), tokenString, JwtIdTokenValidatorMiddlewareOptions{KeySet: keySet, ClaimMapping: mapping})
+	require.Equal(t, "", reason, message)
+	return PutClaims(context.Background(), token.Claims.(*AllClaims))
+}
@@ -0,0 +1,284 @@
+package security
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// reasons why a client certificate is rejected, logged as ReasonFieldName
+const (
+	ClientCertRejectUntrustedProxy = "untrusted_proxy"
+	ClientCertRejectInvalid        = "invalid_certificate"
+	ClientCertRejectNoRule         = "no_matching_rule"
+)
+
+// ClientCertRule maps client certificates to claims.
+//
+// Subject and SAN are regular expressions, start and end markers are added under the hood, as for
+// Policy routes. If both are given, both must match. The first matching rule applies.
+type ClientCertRule struct {
+	// Subject is matched against the subject distinguished name, e.g. "CN=billing,OU=services,O=Example Corp"
+	Subject string
+	// SAN is matched against each DNS name, email address and URI in the subject alternative names,
+	// e.g. "spiffe://cluster.local/ns/billing/sa/.*"
+	SAN string
+
+	// Name defaults to the common name of the certificate subject
+	Name   string
+	Email  string
+	Groups []string
+	Roles  []string
+	Scopes []string
+}
+
+type ClientCertMiddlewareOptions struct {
+	Rules []ClientCertRule
+
+	// ForwardedCertHeader is the request header in which a TLS terminating proxy forwards the client
+	// certificate it has verified, e.g. "X-Forwarded-Client-Cert" (Envoy, Istio) or "Ssl-Client-Cert" (nginx).
+	//
+	// Both the url encoded PEM format and the Envoy format (Cert="<url encoded PEM>") are understood.
+	// Leave empty to only accept certificates from TLS connections terminated by this service.
+	ForwardedCertHeader string
+	// TrustedProxies are the CIDRs of the proxies allowed to set the ForwardedCertHeader, e.g. "127.0.0.1/32"
+	// for a sidecar. The header is rejected from all other addresses.
+	TrustedProxies []string
+	// ForwardedCertRoots optionally verifies forwarded certificates again, in addition to the proxy.
+	ForwardedCertRoots *x509.CertPool
+}
+
+// ClientCertAuthentication authenticates requests by client certificate, see ClientCertMiddlewareOptions.
+//
+// For TLS connections terminated by this service, only certificates verified by the tls.Config are
+// used, so set ClientAuth to tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert there.
+//
+// Requests that an earlier middleware has already authenticated, e.g. by JWT, are left alone, so a
+// sidecar that forwards the certificate of every connection does not replace or reject the token.
+type ClientCertAuthentication struct {
+	rules          []compiledClientCertRule
+	header         string
+	trustedProxies []*net.IPNet
+	roots          *x509.CertPool
+}
+
+type compiledClientCertRule struct {
+	ClientCertRule
+	subject *regexp.Regexp
+	san     *regexp.Regexp
+}
+
+// NewClientCertAuthentication compiles the rules and proxy addresses.
+func NewClientCertAuthentication(options ClientCertMiddlewareOptions) (*ClientCertAuthentication, error) {
+	result := &ClientCertAuthentication{
+		header: options.ForwardedCertHeader,
+		roots:  options.ForwardedCertRoots,
+	}
+	for i, rule := range options.Rules {
+		if rule.Subject == "" && rule.SAN == "" {
+			return nil, fmt.Errorf("client certificate rule #%d needs a Subject or SAN pattern", i+1)
+		}
+		compiled := compiledClientCertRule{ClientCertRule: rule}
+		var err error
+		if compiled.subject, err = compileFullMatch(rule.Subject); err != nil {
+			return nil, fmt.Errorf("client certificate rule #%d subject pattern is invalid: %w", i+1, err)
+		}
+		if compiled.san, err = compileFullMatch(rule.SAN); err != nil {
+			return nil, fmt.Errorf("client certificate rule #%d SAN pattern is invalid: %w", i+1, err)
+		}
+		result.rules = append(result.rules, compiled)
+	}
+	for _, cidr := range options.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy '%s' is not a valid CIDR: %w", cidr, err)
+		}
+		result.trustedProxies = append(result.trustedProxies, ipNet)
+	}
+	if result.header != "" && len(result.trustedProxies) == 0 {
+		return nil, errors.New("a forwarded client certificate header needs trusted proxies")
+	}
+	return result, nil
+}
+
+func compileFullMatch(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^" + pattern + "$")
+}
+
+func (c *ClientCertAuthentication) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if GetClaims(ctx) != nil {
+			// valid case, already authenticated, the credentials take precedence over the certificate
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cert, reason, message := c.clientCertificate(r)
+		if reason != "" {
+			rejectedErrorHandler(ctx, w, r, reason, message, Now())
+			return
+		}
+		if cert == nil {
+			// valid case, no client certificate provided, fall through
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims := c.claimsFor(cert)
+		if claims == nil {
+			rejectedErrorHandler(ctx, w, r, ClientCertRejectNoRule, fmt.Sprintf("no rule for client certificate %s", cert.Subject.String()), Now())
+			return
+		}
+		ctx = PutClaims(ctx, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// clientCertificate returns the verified certificate, or nil, or the reason and a message why it was rejected
+func (c *ClientCertAuthentication) clientCertificate(r *http.Request) (*x509.Certificate, string, string) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return r.TLS.VerifiedChains[0][0], "", ""
+	}
+	if c.header == "" {
+		return nil, "", ""
+	}
+	headerValue := r.Header.Get(c.header)
+	if headerValue == "" {
+		return nil, "", ""
+	}
+	if !c.isTrustedProxy(r.RemoteAddr) {
+		return nil, ClientCertRejectUntrustedProxy, fmt.Sprintf("%s header from untrusted address %s", c.header, r.RemoteAddr)
+	}
+
+	cert, err := parseForwardedCert(headerValue)
+	if err != nil {
+		return nil, ClientCertRejectInvalid, fmt.Sprintf("%s header: %s", c.header, err.Error())
+	}
+	if c.roots != nil {
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:       c.roots,
+			CurrentTime: Now(),
+			KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return nil, ClientCertRejectInvalid, fmt.Sprintf("forwarded client certificate %s: %s", cert.Subject.String(), err.Error())
+		}
+	}
+	return cert, "", ""
+}
+
+func (c *ClientCertAuthentication) isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range c.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedCert understands url encoded PEM, and the Envoy x-forwarded-client-cert format,
+// where the last element is the one added by the proxy closest to us.
+func parseForwardedCert(headerValue string) (*x509.Certificate, error) {
+	encoded := headerValue
+	if strings.Contains(headerValue, "Cert=") {
+		elements := splitOutsideQuotes(headerValue, ',')
+		encoded = ""
+		for _, field := range splitOutsideQuotes(elements[len(elements)-1], ';') {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(field), "Cert="); ok {
+				encoded = strings.Trim(value, `"`)
+			}
+		}
+		if encoded == "" {
+			return nil, errors.New("no Cert in the last element")
+		}
+	}
+
+	// not QueryUnescape, a + in the base64 must stay a +
+	pemString, err := url.PathUnescape(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("not url encoded: %s", err.Error())
+	}
+	block, _ := pem.Decode([]byte(pemString))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// splitOutsideQuotes splits at separators that are not inside double quotes, e.g. in Subject="CN=a,O=b"
+func splitOutsideQuotes(value string, separator rune) []string {
+	parts := make([]string, 0)
+	inQuotes := false
+	start := 0
+	for i, c := range value {
+		if c == '"' {
+			inQuotes = !inQuotes
+		} else if c == separator && !inQuotes {
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+func (c *ClientCertAuthentication) claimsFor(cert *x509.Certificate) *AllClaims {
+	subject := cert.Subject.String()
+	sans := append(append([]string{}, cert.DNSNames...), cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, rule := range c.rules {
+		if rule.subject != nil && !rule.subject.MatchString(subject) {
+			continue
+		}
+		if rule.san != nil && !matchesAny(rule.san, sans) {
+			continue
+		}
+
+		name := rule.Name
+		if name == "" {
+			name = cert.Subject.CommonName
+		}
+		return &AllClaims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: subject},
+			CustomClaims: CustomClaims{
+				Name:   name,
+				Email:  rule.Email,
+				Groups: rule.Groups,
+				Roles:  rule.Roles,
+				Scopes: rule.Scopes,
+			},
+		}
+	}
+	return nil
+}
+
+func matchesAny(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClientCertAuthentication(t *testing.T) {
+	docs.Description("client certificates from TLS or a trusted proxy header are mapped to claims by subject or SAN")
+
+	ca, caKey := tstCertificateAuthority(t)
+	billing := tstClientCert(t, ca, caKey, "billing", "spiffe://cluster.local/ns/billing/sa/billing")
+	reporting := tstClientCert(t, ca, caKey, "reporting", "spiffe://cluster.local/ns/reporting/sa/reporting")
+	unknown := tstClientCert(t, ca, caKey, "unknown", "spiffe://elsewhere/unknown")
+	otherCa, otherCaKey := tstCertificateAuthority(t)
+	foreign := tstClientCert(t, otherCa, otherCaKey, "billing", "spiffe://cluster.local/ns/billing/sa/billing")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	cut, err := NewClientCertAuthentication(ClientCertMiddlewareOptions{
+		Rules: []ClientCertRule{
+			{Subject: "CN=billing,O=Example Corp", Groups: []string{"billing-clients"}},
+			{SAN: "spiffe://cluster.local/ns/reporting/.*", Name: "reports", Roles: []string{"reader"}},
+		},
+		ForwardedCertHeader: "X-Forwarded-Client-Cert",
+		TrustedProxies:      []string{"127.0.0.1/32"},
+		ForwardedCertRoots:  roots,
+	})
+	require.Nil(t, err)
+
+	testcases := []struct {
+		name       string
+		tlsCert    *x509.Certificate
+		header     string
+		remoteAddr string
+		status     int
+		expected   *CustomClaims
+	}{
+		{"verified TLS peer", billing, "", "192.0.2.1:1234", http.StatusNoContent, &CustomClaims{Name: "billing", Groups: []string{"billing-clients"}}},
+		{"nginx header", nil, url.PathEscape(tstCertPEM(reporting)), "127.0.0.1:1234", http.StatusNoContent, &CustomClaims{Name: "reports", Roles: []string{"reader"}}},
+		{"envoy header", nil, `By=spiffe://cluster.local/ns/x/sa/x;Cert="` + url.PathEscape(tstCertPEM(billing)) + `";Subject="CN=billing,O=Example Corp";URI=spiffe://cluster.local/ns/billing/sa/billing`, "127.0.0.1:1234", http.StatusNoContent, &CustomClaims{Name: "billing", Groups: []string{"billing-clients"}}},
+		{"header from untrusted address", nil, url.PathEscape(tstCertPEM(billing)), "192.0.2.1:1234", http.StatusUnauthorized, nil},
+		{"header from other CA", nil, url.PathEscape(tstCertPEM(foreign)), "127.0.0.1:1234", http.StatusUnauthorized, nil},
+		{"garbage header", nil, "not a certificate", "127.0.0.1:1234", http.StatusUnauthorized, nil},
+		{"no matching rule", unknown, "", "192.0.2.1:1234", http.StatusUnauthorized, nil},
+		{"no certificate", nil, "", "192.0.2.1:1234", http.StatusNoContent, nil},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/api", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.tlsCert != nil {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.tlsCert, ca}}}
+			}
+			if tc.header != "" {
+				r.Header.Set("X-Forwarded-Client-Cert", tc.header)
+			}
+			var claims *AllClaims
+			w := httptest.NewRecorder()
+			cut.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims = GetClaims(r.Context())
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+
+			require.Equal(t, tc.status, w.Code)
+			if tc.expected == nil {
+				require.Nil(t, claims)
+			} else {
+				require.Equal(t, *tc.expected, claims.CustomClaims)
+				require.NotEmpty(t, claims.Subject)
+			}
+		})
+	}
+}
+
+func TestNewClientCertAuthentication_Invalid(t *testing.T) {
+	docs.Description("invalid client certificate options are reported at setup")
+
+	for _, options := range []ClientCertMiddlewareOptions{
+		{Rules: []ClientCertRule{{Name: "no patterns"}}},
+		{Rules: []ClientCertRule{{Subject: "CN=(unclosed"}}},
+		{ForwardedCertHeader: "X-Forwarded-Client-Cert"},
+		{ForwardedCertHeader: "X-Forwarded-Client-Cert", TrustedProxies: []string{"10.0.0.1"}},
+	} {
+		_, err := NewClientCertAuthentication(options)
+		require.NotNil(t, err)
+	}
+}
+
+// --- helpers ---
+
+func tstCertificateAuthority(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key := tstMustGenerateEcKey(t, elliptic.P256())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert, key
+}
+
+func tstClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string, uri string) *x509.Certificate {
+	key := tstMustGenerateEcKey(t, elliptic.P256())
+	spiffeId, err := url.Parse(uri)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Example Corp"}},
+		URIs:         []*url.URL{spiffeId},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert
+}
+
+func tstCertPEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
@@ -0,0 +1,239 @@
+package security
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-http-utils/headers"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reasons why an introspected token is rejected, logged as ReasonFieldName
+const (
+	IntrospectionRejectInactive = "inactive"
+	IntrospectionRejectFailed   = "introspection_failed"
+)
+
+type TokenIntrospectionOptions struct {
+	// IntrospectionUrl is the RFC 7662 token introspection endpoint, e.g. https://idp.example.com/oauth2/introspect
+	IntrospectionUrl string
+	// ClientId and ClientSecret authenticate this service at the introspection endpoint
+	ClientId     string
+	ClientSecret string
+
+	// IntrospectJwts also introspects bearer tokens that look like a JWT. By default, they are left to
+	// the JwtIdTokenValidatorMiddleware, and only opaque tokens are introspected.
+	IntrospectJwts bool
+
+	// NegativeCacheTtl is how long inactive tokens are remembered, default 1 minute.
+	// Active tokens are remembered until their exp.
+	NegativeCacheTtl time.Duration
+	// MaxCacheTtl limits how long active tokens are remembered, e.g. to notice revoked tokens earlier.
+	// Default is no limit. Active tokens without exp are not cached.
+	MaxCacheTtl time.Duration
+	// MaxCacheEntries limits the cache size, default 10000
+	MaxCacheEntries int
+
+	// ClaimMapping configures where name, email, groups, roles and scopes are taken from in the
+	// introspection response. The name defaults to the name, then the username field.
+	ClaimMapping ClaimMapping
+
+	// HttpClient is used to call the introspection endpoint, default is a client with a 10 second timeout
+	HttpClient *http.Client
+}
+
+// TokenIntrospection validates opaque bearer tokens at the introspection endpoint of the
+// authorization server, see TokenIntrospectionOptions.
+type TokenIntrospection struct {
+	options TokenIntrospectionOptions
+
+	lock  sync.RWMutex
+	cache map[[sha256.Size]byte]introspectionResult
+}
+
+type introspectionResult struct {
+	claims  *AllClaims // nil if inactive
+	expires time.Time
+}
+
+// NewTokenIntrospection checks the options. It does not call the introspection endpoint yet.
+func NewTokenIntrospection(options TokenIntrospectionOptions) (*TokenIntrospection, error) {
+	if options.IntrospectionUrl == "" || options.ClientId == "" {
+		return nil, errors.New("token introspection needs an IntrospectionUrl and a ClientId")
+	}
+	if err := options.ClaimMapping.Validate(); err != nil {
+		return nil, err
+	}
+	if len(options.ClaimMapping.Name) == 0 {
+		options.ClaimMapping.Name = []string{"name", "username"}
+	}
+	if options.NegativeCacheTtl <= 0 {
+		options.NegativeCacheTtl = time.Minute
+	}
+	if options.MaxCacheEntries <= 0 {
+		options.MaxCacheEntries = 10000
+	}
+	if options.HttpClient == nil {
+		options.HttpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &TokenIntrospection{
+		options: options,
+		cache:   make(map[[sha256.Size]byte]introspectionResult),
+	}, nil
+}
+
+// Middleware introspects bearer tokens and puts the claims into the context.
+//
+// Place it before the JwtIdTokenValidatorMiddleware, which leaves the bearer tokens alone that were introspected.
+func (i *TokenIntrospection) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		authHeaderValue := r.Header.Get(headers.Authorization)
+		const bearerPrefix = "Bearer "
+		tokenString := strings.TrimSpace(strings.TrimPrefix(authHeaderValue, bearerPrefix))
+		if !strings.HasPrefix(authHeaderValue, bearerPrefix) || (!i.options.IntrospectJwts && looksLikeJwt(tokenString)) {
+			// valid case, no bearer token for us, fall through
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		claims, err := i.Introspect(ctx, tokenString)
+		if err != nil {
+			rejectedErrorHandler(ctx, w, r, IntrospectionRejectFailed, err.Error(), Now())
+			return
+		}
+		if claims == nil {
+			rejectedErrorHandler(ctx, w, r, IntrospectionRejectInactive, "token is not active", Now())
+			return
+		}
+		ctx = PutRawToken(ctx, tokenString)
+		ctx = putValidatedBearer(ctx, tokenString)
+		ctx = PutClaims(ctx, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Introspect returns the claims of an active token, or nil if the token is not active.
+//
+// Results are cached, errors are not. Each call returns its own copy of the claims.
+func (i *TokenIntrospection) Introspect(ctx context.Context, tokenString string) (*AllClaims, error) {
+	key := sha256.Sum256([]byte(tokenString))
+	now := Now()
+
+	i.lock.RLock()
+	cached, ok := i.cache[key]
+	i.lock.RUnlock()
+	if ok && now.Before(cached.expires) {
+		if cached.claims == nil {
+			return nil, nil
+		}
+		return cached.claims.copy(), nil
+	}
+
+	claims, err := i.call(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	result := introspectionResult{claims: claims, expires: now.Add(i.options.NegativeCacheTtl)}
+	if claims != nil {
+		if claims.ExpiresAt == nil {
+			return claims, nil
+		}
+		result.expires = claims.ExpiresAt.Time
+		if i.options.MaxCacheTtl > 0 && result.expires.After(now.Add(i.options.MaxCacheTtl)) {
+			result.expires = now.Add(i.options.MaxCacheTtl)
+		}
+	}
+	i.store(key, result, now)
+	if claims == nil {
+		return nil, nil
+	}
+	return claims.copy(), nil
+}
+
+func (i *TokenIntrospection) store(key [sha256.Size]byte, result introspectionResult, now time.Time) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if len(i.cache) >= i.options.MaxCacheEntries {
+		for k, v := range i.cache {
+			if !now.Before(v.expires) {
+				delete(i.cache, k)
+			}
+		}
+		if len(i.cache) >= i.options.MaxCacheEntries {
+			return
+		}
+	}
+	i.cache[key] = result
+}
+
+// call asks the introspection endpoint, and returns the claims, or nil if the token is not active
+func (i *TokenIntrospection) call(ctx context.Context, tokenString string) (*AllClaims, error) {
+	form := url.Values{}
+	form.Set("token", tokenString)
+	form.Set("token_type_hint", "access_token")
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, i.options.IntrospectionUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set(headers.ContentType, "application/x-www-form-urlencoded")
+	request.Header.Set(headers.Accept, "application/json")
+	// RFC 6749 section 2.3.1 requires url encoding the client credentials
+	request.SetBasicAuth(url.QueryEscape(i.options.ClientId), url.QueryEscape(i.options.ClientSecret))
+
+	response, err := i.options.HttpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from introspection endpoint", response.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	return parseIntrospectionResponse(body, i.options.ClaimMapping, Now())
+}
+
+func parseIntrospectionResponse(body []byte, mapping ClaimMapping, now time.Time) (*AllClaims, error) {
+	active := struct {
+		Active bool `json:"active"`
+	}{}
+	if err := json.Unmarshal(body, &active); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %s", err.Error())
+	}
+	if !active.Active {
+		return nil, nil
+	}
+
+	claims := AllClaims{}
+	if err := json.Unmarshal(body, &claims.RegisteredClaims); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %s", err.Error())
+	}
+	if err := json.Unmarshal(body, &claims.Raw); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %s", err.Error())
+	}
+	mapping.Apply(&claims)
+
+	// the authorization server should not report expired tokens as active, but the clocks may differ
+	if claims.ExpiresAt != nil && !now.Before(claims.ExpiresAt.Time) {
+		return nil, nil
+	}
+	return &claims, nil
+}
+
+func looksLikeJwt(tokenString string) bool {
+	return strings.Count(tokenString, ".") == 2
+}
@@ -0,0 +1,225 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenIntrospection_Middleware(t *testing.T) {
+	docs.Description("opaque bearer tokens are introspected with client credentials, and the response fills the claims")
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer tstSetNow(&now)()
+
+	server := tstNewIntrospectionServer(t, map[string]map[string]interface{}{
+		"opaque-valid": {
+			"active":    true,
+			"sub":       "user-1234",
+			"username":  "jdoe",
+			"client_id": "gateway",
+			"scope":     "openid rooms:read",
+			"groups":    []string{"users"},
+			"aud":       "room-service",
+			"exp":       now.Add(time.Hour).Unix(),
+		},
+		"opaque-expired": {"active": true, "exp": now.Add(-time.Second).Unix()},
+	})
+	cut := tstNewTokenIntrospection(t, server, TokenIntrospectionOptions{})
+
+	response, claims := tstIntrospectionRequest(cut.Middleware, "Bearer opaque-valid")
+	require.Equal(t, http.StatusNoContent, response.Code)
+	require.Equal(t, "user-1234", claims.Subject)
+	require.Equal(t, "jdoe", claims.Name)
+	require.Equal(t, []string{"users"}, claims.Groups)
+	require.Equal(t, []string{"openid", "rooms:read"}, claims.Scopes)
+	require.Equal(t, []string{"room-service"}, []string(claims.Audience))
+	require.Equal(t, "gateway", claims.Raw["client_id"])
+
+	for _, authorization := range []string{"Bearer opaque-unknown", "Bearer opaque-expired"} {
+		response, claims = tstIntrospectionRequest(cut.Middleware, authorization)
+		require.Equal(t, http.StatusUnauthorized, response.Code, authorization)
+		require.Nil(t, claims)
+	}
+
+	// left to the jwt middleware
+	for _, authorization := range []string{"", "Basic dGVzdHVzZXI6dGVzdHB3", "Bearer header.payload.signature"} {
+		response, claims = tstIntrospectionRequest(cut.Middleware, authorization)
+		require.Equal(t, http.StatusNoContent, response.Code, authorization)
+		require.Nil(t, claims)
+	}
+	require.Equal(t, 3, server.calls())
+}
+
+func TestTokenIntrospection_Cache(t *testing.T) {
+	docs.Description("active tokens are cached until exp, inactive tokens for the negative cache ttl, errors are not cached")
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer tstSetNow(&now)()
+
+	server := tstNewIntrospectionServer(t, map[string]map[string]interface{}{
+		"opaque-valid": {"active": true, "exp": now.Add(10 * time.Minute).Unix()},
+	})
+	cut := tstNewTokenIntrospection(t, server, TokenIntrospectionOptions{NegativeCacheTtl: time.Minute})
+
+	for j := 0; j < 3; j++ {
+		response, _ := tstIntrospectionRequest(cut.Middleware, "Bearer opaque-valid")
+		require.Equal(t, http.StatusNoContent, response.Code)
+		response, _ = tstIntrospectionRequest(cut.Middleware, "Bearer opaque-revoked")
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+	}
+	require.Equal(t, 2, server.calls())
+
+	now = now.Add(time.Minute)
+	_, _ = tstIntrospectionRequest(cut.Middleware, "Bearer opaque-valid")
+	_, _ = tstIntrospectionRequest(cut.Middleware, "Bearer opaque-revoked")
+	require.Equal(t, 3, server.calls())
+
+	now = now.Add(10 * time.Minute)
+	response, _ := tstIntrospectionRequest(cut.Middleware, "Bearer opaque-valid")
+	require.Equal(t, http.StatusUnauthorized, response.Code)
+	require.Equal(t, 4, server.calls())
+
+	server.fail = true
+	response, _ = tstIntrospectionRequest(cut.Middleware, "Bearer opaque-other")
+	require.Equal(t, http.StatusUnauthorized, response.Code)
+	server.fail = false
+	response, _ = tstIntrospectionRequest(cut.Middleware, "Bearer opaque-other")
+	require.Equal(t, http.StatusUnauthorized, response.Code)
+	require.Equal(t, 6, server.calls())
+}
+
+func TestJwtIdTokenValidatorMiddleware_AfterIntrospection(t *testing.T) {
+	docs.Description("the jwt middleware leaves bearer tokens alone that were already accepted by introspection")
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer tstSetNow(&now)()
+
+	server := tstNewIntrospectionServer(t, map[string]map[string]interface{}{
+		"opaque-valid": {"active": true, "sub": "user-1234"},
+	})
+	introspection := tstNewTokenIntrospection(t, server, TokenIntrospectionOptions{})
+	jwtMiddleware := JwtIdTokenValidatorMiddleware(JwtIdTokenValidatorMiddlewareOptions{})
+
+	response, claims := tstIntrospectionRequest(func(next http.Handler) http.Handler {
+		return introspection.Middleware(jwtMiddleware(next))
+	}, "Bearer opaque-valid")
+	require.Equal(t, http.StatusNoContent, response.Code)
+	require.Equal(t, "user-1234", claims.Subject)
+
+	// claims put by any other middleware do not skip signature validation
+	response, claims = tstIntrospectionRequest(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := PutClaims(r.Context(), &AllClaims{CustomClaims: CustomClaims{Name: "billing"}})
+			jwtMiddleware(next).ServeHTTP(w, r.WithContext(ctx))
+		})
+	}, "Bearer eyJhbGciOiJub25lIn0.eyJzdWIiOiJhZG1pbiJ9.")
+	require.Equal(t, http.StatusUnauthorized, response.Code)
+	require.Nil(t, claims)
+}
+
+func TestTokenIntrospection_CachedClaimsCopied(t *testing.T) {
+	docs.Description("each caller gets its own copy of cached claims")
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer tstSetNow(&now)()
+
+	server := tstNewIntrospectionServer(t, map[string]map[string]interface{}{
+		"opaque-valid": {"active": true, "exp": now.Add(10 * time.Minute).Unix(), "groups": []string{"users"}, "tenant": map[string]interface{}{"id": "acme"}},
+	})
+	cut := tstNewTokenIntrospection(t, server, TokenIntrospectionOptions{ClaimMapping: ClaimMapping{Groups: []string{"groups"}}})
+
+	first, err := cut.Introspect(context.Background(), "opaque-valid")
+	require.Nil(t, err)
+	first.Groups[0] = "admins"
+	first.Raw["tenant"].(map[string]interface{})["id"] = "other"
+
+	second, err := cut.Introspect(context.Background(), "opaque-valid")
+	require.Nil(t, err)
+	require.Equal(t, []string{"users"}, second.Groups)
+	require.Equal(t, "acme", second.Raw["tenant"].(map[string]interface{})["id"])
+	require.Equal(t, 1, server.calls())
+}
+
+func TestNewTokenIntrospection_Invalid(t *testing.T) {
+	docs.Description("token introspection needs an endpoint and client id")
+
+	_, err := NewTokenIntrospection(TokenIntrospectionOptions{ClientId: "room-service"})
+	require.NotNil(t, err)
+	_, err = NewTokenIntrospection(TokenIntrospectionOptions{IntrospectionUrl: "http://localhost/introspect"})
+	require.NotNil(t, err)
+	_, err = NewTokenIntrospection(TokenIntrospectionOptions{IntrospectionUrl: "http://localhost/introspect", ClientId: "x", ClaimMapping: ClaimMapping{Groups: []string{"a..b"}}})
+	require.NotNil(t, err)
+}
+
+// --- helpers ---
+
+type tstIntrospectionServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	counter int
+	fail    bool
+}
+
+func (s *tstIntrospectionServer) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counter
+}
+
+func tstNewIntrospectionServer(t *testing.T, tokens map[string]map[string]interface{}) *tstIntrospectionServer {
+	server := &tstIntrospectionServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.counter++
+		server.mu.Unlock()
+
+		clientId, clientSecret, ok := r.BasicAuth()
+		if !ok || clientId != "room%2Fservice" || clientSecret != "s3cret%21" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if server.fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		response, ok := tokens[r.PostFormValue("token")]
+		if !ok {
+			response = map[string]interface{}{"active": false}
+		}
+		w.Header().Set(headers.ContentType, "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func tstNewTokenIntrospection(t *testing.T, server *tstIntrospectionServer, options TokenIntrospectionOptions) *TokenIntrospection {
+	options.IntrospectionUrl = server.URL + "/introspect"
+	options.ClientId = "room/service"
+	options.ClientSecret = "s3cret!"
+	cut, err := NewTokenIntrospection(options)
+	require.Nil(t, err)
+	return cut
+}
+
+func tstIntrospectionRequest(middleware func(http.Handler) http.Handler, authorization string) (*httptest.ResponseRecorder, *AllClaims) {
+	var claims *AllClaims
+	r := httptest.NewRequest(http.MethodGet, "/v1/api", nil)
+	if authorization != "" {
+		r.Header.Set(headers.Authorization, authorization)
+	}
+	w := httptest.NewRecorder()
+	middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims = GetClaims(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(w, r)
+	return w, claims
+}
@@ -0,0 +1,323 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/go-http-utils/headers"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownKid is returned by JwksKeySet.Key if the key set does not contain the key, even after a refresh.
+var ErrUnknownKid = errors.New("no key with this kid in key set")
+
+type JwksKeySetOptions struct {
+	// JwksUrl is the url of the JSON Web Key Set, e.g. https://idp.example.com/protocol/openid-connect/certs
+	JwksUrl string
+	// DiscoveryUrl is used to look up the jwks_uri if JwksUrl is empty.
+	//
+	// You can give either the issuer or the full url ending in /.well-known/openid-configuration.
+	DiscoveryUrl string
+
+	// RefreshInterval is how long the keys are used before they are fetched again, default 1 hour
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits how often a token with an unknown kid can trigger a refresh, default 1 minute
+	MinRefreshInterval time.Duration
+	// RefreshTimeout limits how long a refresh may take, default 10 seconds.
+	//
+	// Refreshes are not cancelled when the request that triggered them is.
+	RefreshTimeout time.Duration
+
+	// HttpClient is used to fetch the keys, default is a client with a 10 second timeout
+	HttpClient *http.Client
+}
+
+// JwksKeySet is a cache of the public keys of an identity provider, selected by kid.
+//
+// The keys are refreshed when they are older than RefreshInterval, and when a token refers to a kid
+// that is not in the key set, so key rotations at the identity provider need no redeploy. If a refresh
+// fails, the previous keys remain in use.
+type JwksKeySet struct {
+	options JwksKeySetOptions
+
+	lock        sync.RWMutex
+	keys        map[string]VerificationKey
+	lastRefresh time.Time
+
+	refreshLock sync.Mutex
+}
+
+type jwksDocument struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JwksUri string `json:"jwks_uri"`
+}
+
+// NewJwksKeySet fetches the keys for the first time.
+//
+// Fails if they cannot be fetched, because the service would reject all tokens anyway.
+func NewJwksKeySet(ctx context.Context, options JwksKeySetOptions) (*JwksKeySet, error) {
+	if options.JwksUrl == "" && options.DiscoveryUrl == "" {
+		return nil, errors.New("one of JwksUrl or DiscoveryUrl must be set")
+	}
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = time.Hour
+	}
+	if options.MinRefreshInterval <= 0 {
+		options.MinRefreshInterval = time.Minute
+	}
+	if options.RefreshTimeout <= 0 {
+		options.RefreshTimeout = 10 * time.Second
+	}
+	if options.HttpClient == nil {
+		options.HttpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	keySet := &JwksKeySet{
+		options: options,
+		keys:    make(map[string]VerificationKey),
+	}
+	if err := keySet.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return keySet, nil
+}
+
+// Key returns the public key with the given kid.
+//
+// The allowed algorithm is taken from the alg of the key, or the default for the key type if the key has no alg.
+func (k *JwksKeySet) Key(ctx context.Context, kid string) (VerificationKey, error) {
+	key, ok, age := k.lookup(kid)
+	if ok && age < k.options.RefreshInterval {
+		return key, nil
+	}
+
+	// stale or unknown kid, but do not let unknown kids make us hammer the identity provider
+	if ok {
+		// no need to wait for a refresh that is already running, the stale key is still good
+		if k.refreshLock.TryLock() {
+			k.refreshIfOlderLocked(ctx, k.options.MinRefreshInterval)
+			k.refreshLock.Unlock()
+			key, ok, _ = k.lookup(kid)
+		}
+	} else if age >= k.options.MinRefreshInterval {
+		k.refreshLock.Lock()
+		k.refreshIfOlderLocked(ctx, k.options.MinRefreshInterval)
+		k.refreshLock.Unlock()
+		key, ok, _ = k.lookup(kid)
+	}
+	if !ok {
+		return VerificationKey{}, ErrUnknownKid
+	}
+	return key, nil
+}
+
+// Kids returns the kids of all keys currently in the key set.
+func (k *JwksKeySet) Kids() []string {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	result := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		result = append(result, kid)
+	}
+	return result
+}
+
+func (k *JwksKeySet) lookup(kid string) (VerificationKey, bool, time.Duration) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	key, ok := k.keys[kid]
+	return key, ok, Now().Sub(k.lastRefresh)
+}
+
+// refreshIfOlderLocked refreshes the keys unless another request has refreshed them in the meantime.
+//
+// The caller must hold refreshLock. The refresh does not use the cancellation of the triggering request, so
+// a client that disconnects does not make the refresh fail for everyone else.
+func (k *JwksKeySet) refreshIfOlderLocked(ctx context.Context, minAge time.Duration) {
+	k.lock.RLock()
+	age := Now().Sub(k.lastRefresh)
+	k.lock.RUnlock()
+	if age < minAge {
+		return
+	}
+
+	refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), k.options.RefreshTimeout)
+	defer cancel()
+	if err := k.refresh(refreshCtx); err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to refresh JWKS key set - continuing with previous keys: %s", err.Error())
+		// try again after MinRefreshInterval, not on every request
+		k.lock.Lock()
+		k.lastRefresh = Now().Add(minAge - k.options.RefreshInterval)
+		k.lock.Unlock()
+	}
+}
+
+func (k *JwksKeySet) refresh(ctx context.Context) error {
+	jwksUrl := k.options.JwksUrl
+	if jwksUrl == "" {
+		discovery := discoveryDocument{}
+		if err := k.fetch(ctx, discoveryUrl(k.options.DiscoveryUrl), &discovery); err != nil {
+			return fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+		}
+		if discovery.JwksUri == "" {
+			return errors.New("OIDC discovery document contains no jwks_uri")
+		}
+		jwksUrl = discovery.JwksUri
+	}
+
+	document := jwksDocument{}
+	if err := k.fetch(ctx, jwksUrl, &document); err != nil {
+		return fmt.Errorf("failed to fetch JWKS key set: %w", err)
+	}
+
+	keys := make(map[string]VerificationKey)
+	for _, key := range document.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		verificationKey, err := parseJwk(key)
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("skipping JWKS key with kid '%s': %s", key.Kid, err.Error())
+			continue
+		}
+		keys[key.Kid] = verificationKey
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS key set contains no usable keys")
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.keys = keys
+	k.lastRefresh = Now()
+	aulogging.Logger.Ctx(ctx).Info().Printf("fetched %d keys from JWKS key set %s", len(keys), jwksUrl)
+	return nil
+}
+
+func (k *JwksKeySet) fetch(ctx context.Context, url string, target interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set(headers.Accept, "application/json")
+
+	response, err := k.options.HttpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", response.StatusCode, url)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, target)
+}
+
+func discoveryUrl(issuerOrUrl string) string {
+	const wellKnown = "/.well-known/openid-configuration"
+	if strings.HasSuffix(issuerOrUrl, wellKnown) {
+		return issuerOrUrl
+	}
+	return strings.TrimSuffix(issuerOrUrl, "/") + wellKnown
+}
+
+func parseJwk(key jwk) (VerificationKey, error) {
+	publicKey, err := jwkPublicKey(key)
+	if err != nil {
+		return VerificationKey{}, err
+	}
+
+	algorithms := make([]string, 0)
+	if key.Alg != "" {
+		algorithms = append(algorithms, key.Alg)
+	}
+	verificationKey, err := NewVerificationKey(publicKey, algorithms...)
+	if err != nil {
+		return VerificationKey{}, err
+	}
+	verificationKey.Kid = key.Kid
+	return verificationKey, nil
+}
+
+func jwkPublicKey(key jwk) (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 || exponent.Int64() < 3 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		curve, ok := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[key.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve '%s'", key.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(key.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		publicKey := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return publicKey, nil
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", key.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", key.Kty)
+	}
+}
@@ -0,0 +1,215 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/go-http-utils/headers"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJwksKeySet_SelectsKeyByKid(t *testing.T) {
+	docs.Description("jwt middleware with a JWKS key set selects the key by kid")
+
+	idp := tstNewIdp(t, "key1", "key2")
+	keySet, err := NewJwksKeySet(context.Background(), JwksKeySetOptions{JwksUrl: idp.server.URL + "/certs"})
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"key1", "key2"}, keySet.Kids())
+
+	require.Equal(t, http.StatusNoContent, tstJwksRequest(t, keySet, idp.token(t, "key2", "key2")))
+	require.Equal(t, http.StatusUnauthorized, tstJwksRequest(t, keySet, idp.token(t, "key2", "key1")))
+	require.Equal(t, 1, idp.fetches())
+}
+
+func TestJwksKeySet_RotationOnUnknownKid(t *testing.T) {
+	docs.Description("a token with an unknown kid triggers a refresh of the key set, but at most once per MinRefreshInterval")
+
+	now := time.Now()
+	defer tstSetNow(&now)()
+
+	idp := tstNewIdp(t, "old")
+	keySet, err := NewJwksKeySet(context.Background(), JwksKeySetOptions{DiscoveryUrl: idp.server.URL})
+	require.Nil(t, err)
+
+	idp.rotate(t, "new")
+	now = now.Add(10 * time.Second)
+	require.Equal(t, http.StatusUnauthorized, tstJwksRequest(t, keySet, idp.token(t, "new", "new")))
+	require.Equal(t, 1, idp.fetches())
+
+	now = now.Add(time.Minute)
+	require.Equal(t, http.StatusNoContent, tstJwksRequest(t, keySet, idp.token(t, "new", "new")))
+	require.Equal(t, 2, idp.fetches())
+	require.Equal(t, []string{"new"}, keySet.Kids())
+}
+
+func TestJwksKeySet_PeriodicRefresh(t *testing.T) {
+	docs.Description("keys are refreshed after RefreshInterval, and kept if the refresh fails")
+
+	now := time.Now()
+	defer tstSetNow(&now)()
+
+	idp := tstNewIdp(t, "key1")
+	keySet, err := NewJwksKeySet(context.Background(), JwksKeySetOptions{JwksUrl: idp.server.URL + "/certs", RefreshInterval: time.Hour})
+	require.Nil(t, err)
+
+	now = now.Add(2 * time.Hour)
+	require.Equal(t, http.StatusNoContent, tstJwksRequest(t, keySet, idp.token(t, "key1", "key1")))
+	require.Equal(t, 2, idp.fetches())
+
+	idp.fail()
+	now = now.Add(2 * time.Hour)
+	require.Equal(t, http.StatusNoContent, tstJwksRequest(t, keySet, idp.token(t, "key1", "key1")))
+	require.Equal(t, http.StatusNoContent, tstJwksRequest(t, keySet, idp.token(t, "key1", "key1")))
+	require.Equal(t, 3, idp.fetches())
+}
+
+func TestJwksKeySet_RefreshOutlivesRequest(t *testing.T) {
+	docs.Description("a refresh triggered by a request is not cancelled when that request is")
+
+	now := time.Now()
+	defer tstSetNow(&now)()
+
+	idp := tstNewIdp(t, "old")
+	keySet, err := NewJwksKeySet(context.Background(), JwksKeySetOptions{JwksUrl: idp.server.URL + "/certs"})
+	require.Nil(t, err)
+
+	idp.rotate(t, "new")
+	now = now.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = keySet.Key(ctx, "new")
+	require.Nil(t, err)
+	require.Equal(t, 2, idp.fetches())
+}
+
+func TestJwksKeySet_Unavailable(t *testing.T) {
+	docs.Description("creating a JWKS key set fails if the keys cannot be fetched")
+
+	idp := tstNewIdp(t, "key1")
+	idp.fail()
+	_, err := NewJwksKeySet(context.Background(), JwksKeySetOptions{JwksUrl: idp.server.URL + "/certs"})
+	require.NotNil(t, err)
+}
+
+// --- helpers ---
+
+type tstIdp struct {
+	server *httptest.Server
+
+	lock       sync.Mutex
+	keys       map[string]*rsa.PrivateKey
+	fetchCount int
+	failing    bool
+}
+
+func tstNewIdp(t *testing.T, kids ...string) *tstIdp {
+	idp := &tstIdp{}
+	idp.rotate(t, kids...)
+
+	router := http.NewServeMux()
+	router.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentType, "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": idp.server.URL, "jwks_uri": idp.server.URL + "/certs"})
+	})
+	router.HandleFunc("/certs", func(w http.ResponseWriter, r *http.Request) {
+		idp.lock.Lock()
+		defer idp.lock.Unlock()
+		idp.fetchCount++
+		if idp.failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		document := jwksDocument{}
+		for kid, key := range idp.keys {
+			document.Keys = append(document.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		w.Header().Set(headers.ContentType, "application/json")
+		_ = json.NewEncoder(w).Encode(document)
+	})
+	idp.server = httptest.NewServer(router)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (i *tstIdp) rotate(t *testing.T, kids ...string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.keys = make(map[string]*rsa.PrivateKey)
+	for _, kid := range kids {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.Nil(t, err)
+		i.keys[kid] = key
+	}
+}
+
+func (i *tstIdp) fail() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.failing = true
+}
+
+func (i *tstIdp) fetches() int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.fetchCount
+}
+
+// token signs a token with the key signingKid, but puts kid in the header
+func (i *tstIdp) token(t *testing.T, signingKid string, kid string) string {
+	return i.tokenWithClaims(t, signingKid, kid, jwt.MapClaims{
+		"exp":  Now().Add(time.Hour).Unix(),
+		"name": "John Doe",
+	})
+}
+
+func (i *tstIdp) tokenWithClaims(t *testing.T, signingKid string, kid string, claims jwt.MapClaims) string {
+	i.lock.Lock()
+	key := i.keys[signingKid]
+	i.lock.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.Nil(t, err)
+	return "Bearer " + signed
+}
+
+func tstJwksRequest(t *testing.T, keySet *JwksKeySet, authorization string) int {
+	handler := JwtIdTokenValidatorMiddleware(JwtIdTokenValidatorMiddlewareOptions{KeySet: keySet})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "John Doe", Name(r.Context()))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/api", nil)
+	r.Header.Add(headers.Authorization, authorization)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+// tstSetNow makes Now return *now, and returns a function to restore it
+func tstSetNow(now *time.Time) func() {
+	original := Now
+	Now = func() time.Time {
+		return *now
+	}
+	return func() {
+		Now = original
+	}
+}
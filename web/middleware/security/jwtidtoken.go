@@ -1,17 +1,64 @@
 package security
 
 import (
+	"context"
 	"crypto/rsa"
+	"errors"
+	"github.com/StephanHCB/go-backend-service-common/repository/metrics"
 	"github.com/go-http-utils/headers"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type JwtIdTokenValidatorMiddlewareOptions struct {
+	// PublicKeys are RSA keys that allow RS256, use VerificationKeys for other key types and algorithms
 	PublicKeys []*rsa.PublicKey
+	// VerificationKeys are keys with their allowed algorithms, see ParseVerificationKeysFromPEM
+	VerificationKeys []VerificationKey
+
+	// KeySet selects the key by the kid in the token header. Tried before PublicKeys and VerificationKeys.
+	//
+	// If both are given, PublicKeys and VerificationKeys are only tried for tokens whose kid is not in the key set.
+	KeySet *JwksKeySet
+
+	// Issuers is the list of accepted values for the iss claim. Leave empty to accept any issuer.
+	Issuers []string
+	// Audiences is the list of accepted audiences. The aud claim must contain at least one of them.
+	// Leave empty to accept any audience.
+	Audiences []string
+	// RequiredClaims are the names of claims that must be present and not empty, e.g. "sub", "email"
+	RequiredClaims []string
+	// Leeway is the allowed clock skew for exp, nbf and iat
+	Leeway time.Duration
+
+	// ClaimMapping configures where name, email, groups, roles and scopes are taken from
+	ClaimMapping ClaimMapping
+
+	// Registerer is used for the rejected token counter, leave nil to use metrics.Registerer
+	Registerer prometheus.Registerer
 }
 
+// JwtRejectedCounterName counts rejected tokens, partitioned by the reason label
+var JwtRejectedCounterName = "security_jwt_rejected_total"
+
+// reasons why a token is rejected, used as log field event.reason and as metric label
+const (
+	JwtRejectMalformed       = "malformed"
+	JwtRejectUnknownKey      = "unknown_key"
+	JwtRejectAlgorithm       = "algorithm_not_allowed"
+	JwtRejectSignature       = "invalid_signature"
+	JwtRejectExpired         = "expired"
+	JwtRejectNotYetValid     = "not_yet_valid"
+	JwtRejectIssuedInFuture  = "issued_in_future"
+	JwtRejectIssuer          = "invalid_issuer"
+	JwtRejectAudience        = "invalid_audience"
+	JwtRejectMissingClaim    = "missing_claim"
+	JwtRejectNoKeyConfigured = "no_key_configured"
+)
+
 // ParsePublicKeysFromPEM is a helper function to parse RSA public keys in PEM format
 func ParsePublicKeysFromPEM(publicKeyPEMs []string) ([]*rsa.PublicKey, error) {
 	var rsaPublicKeys = make([]*rsa.PublicKey, 0)
@@ -29,36 +76,37 @@ func ParsePublicKeysFromPEM(publicKeyPEMs []string) ([]*rsa.PublicKey, error) {
 }
 
 func JwtIdTokenValidatorMiddleware(options JwtIdTokenValidatorMiddlewareOptions) func(http.Handler) http.Handler {
+	rejected := metrics.Register(metrics.RegistererOrDefault(options.Registerer), prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: JwtRejectedCounterName,
+			Help: "Number of requests with a JWT bearer token that was rejected, partitioned by reason.",
+		},
+		[]string{"reason"},
+	))
+
 	mw := func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			authHeaderValue := r.Header.Get(headers.Authorization)
 			const bearerPrefix = "Bearer "
+			tokenString := strings.TrimSpace(strings.TrimPrefix(authHeaderValue, bearerPrefix))
 			if !strings.HasPrefix(authHeaderValue, bearerPrefix) {
 				// valid case, no bearer authorization provided
 				next.ServeHTTP(w, r)
+			} else if isValidatedBearer(r.Context(), tokenString) {
+				// valid case, the bearer token was already accepted by TokenIntrospection
+				next.ServeHTTP(w, r)
 			} else {
 				ctx := r.Context()
-				tokenString := strings.TrimSpace(strings.TrimPrefix(authHeaderValue, bearerPrefix))
-
-				errorMessage := ""
-				for _, key := range options.PublicKeys {
-					claims := AllClaims{}
-					token, err := jwt.ParseWithClaims(tokenString, &claims, keyFuncForKey(key), jwt.WithValidMethods([]string{"RS256"}))
-					if err == nil && token.Valid {
-						parsedClaims := token.Claims.(*AllClaims)
-
-						ctx = PutRawToken(ctx, token.Raw)
-						ctx = PutClaims(ctx, parsedClaims)
-						next.ServeHTTP(w, r.WithContext(ctx))
-						return
-					}
-					if err != nil {
-						errorMessage = err.Error()
-					} else if !token.Valid {
-						errorMessage = "token parsed but invalid"
-					}
+
+				token, reason, errorMessage := parseJwt(ctx, tokenString, options)
+				if token != nil {
+					ctx = PutRawToken(ctx, token.Raw)
+					ctx = PutClaims(ctx, token.Claims.(*AllClaims))
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
 				}
-				unauthorizedErrorHandler(ctx, w, r, errorMessage, Now())
+				rejected.WithLabelValues(reason).Inc()
+				rejectedErrorHandler(ctx, w, r, reason, errorMessage, Now())
 			}
 		}
 		return http.HandlerFunc(fn)
@@ -66,8 +114,142 @@ func JwtIdTokenValidatorMiddleware(options JwtIdTokenValidatorMiddlewareOptions)
 	return mw
 }
 
-func keyFuncForKey(rsaPublicKey *rsa.PublicKey) func(token *jwt.Token) (interface{}, error) {
+// parseJwt returns the valid token, or nil, the reason and a message why it was rejected
+func parseJwt(ctx context.Context, tokenString string, options JwtIdTokenValidatorMiddlewareOptions) (*jwt.Token, string, string) {
+	// claims are validated below, because the parser of this jwt version does not support a leeway
+	// the algorithm is checked against the key in the key func
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+
+	var token *jwt.Token
+	reason, errorMessage := JwtRejectNoKeyConfigured, "no keys configured"
+	if options.KeySet != nil {
+		claims := AllClaims{}
+		parsed, err := parser.ParseWithClaims(tokenString, &claims, keyFuncForKeySet(ctx, options.KeySet))
+		if err == nil {
+			token = parsed
+		} else {
+			reason, errorMessage = signatureRejectReason(err), err.Error()
+			if !errors.Is(err, ErrUnknownKid) || len(options.PublicKeys)+len(options.VerificationKeys) == 0 {
+				return nil, reason, errorMessage
+			}
+		}
+	}
+
+	for _, key := range append(verificationKeysFromRSA(options.PublicKeys), options.VerificationKeys...) {
+		if token != nil {
+			break
+		}
+		claims := AllClaims{}
+		parsed, err := parser.ParseWithClaims(tokenString, &claims, keyFuncForKey(key))
+		if err == nil {
+			token = parsed
+		} else {
+			reason, errorMessage = signatureRejectReason(err), err.Error()
+		}
+	}
+	if token == nil {
+		return nil, reason, errorMessage
+	}
+
+	claims := token.Claims.(*AllClaims)
+	rawClaims, err := decodeRawClaims(token.Raw)
+	if err != nil {
+		return nil, JwtRejectMalformed, err.Error()
+	}
+	claims.Raw = rawClaims
+	options.ClaimMapping.Apply(claims)
+
+	if reason, errorMessage := validateJwtClaims(token, options, Now()); reason != "" {
+		return nil, reason, errorMessage
+	}
+	return token, "", ""
+}
+
+func signatureRejectReason(err error) string {
+	validationErr := &jwt.ValidationError{}
+	if !errors.As(err, &validationErr) {
+		return JwtRejectMalformed
+	}
+	if errors.Is(err, ErrAlgorithmNotAllowed) {
+		return JwtRejectAlgorithm
+	}
+	if validationErr.Errors&jwt.ValidationErrorUnverifiable != 0 {
+		return JwtRejectUnknownKey
+	}
+	if validationErr.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+		return JwtRejectSignature
+	}
+	return JwtRejectMalformed
+}
+
+func validateJwtClaims(token *jwt.Token, options JwtIdTokenValidatorMiddlewareOptions, now time.Time) (string, string) {
+	claims := token.Claims.(*AllClaims)
+
+	if claims.ExpiresAt != nil && now.After(claims.ExpiresAt.Add(options.Leeway)) {
+		return JwtRejectExpired, "token is expired"
+	}
+	if claims.NotBefore != nil && now.Before(claims.NotBefore.Add(-options.Leeway)) {
+		return JwtRejectNotYetValid, "token is not valid yet"
+	}
+	if claims.IssuedAt != nil && now.Before(claims.IssuedAt.Add(-options.Leeway)) {
+		return JwtRejectIssuedInFuture, "token used before issued"
+	}
+
+	if len(options.Issuers) > 0 && !contains(options.Issuers, claims.Issuer) {
+		return JwtRejectIssuer, "token has unexpected issuer '" + claims.Issuer + "'"
+	}
+	if len(options.Audiences) > 0 && !containsAny(claims.Audience, options.Audiences) {
+		return JwtRejectAudience, "token is not intended for this audience"
+	}
+
+	for _, name := range options.RequiredClaims {
+		if isEmptyClaim(claims.Raw[name]) {
+			return JwtRejectMissingClaim, "token lacks required claim '" + name + "'"
+		}
+	}
+	return "", ""
+}
+
+func containsAny(haystack []string, needles []string) bool {
+	for _, needle := range needles {
+		if contains(haystack, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyClaim(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+func keyFuncForKeySet(ctx context.Context, keySet *JwksKeySet) func(token *jwt.Token) (interface{}, error) {
 	return func(token *jwt.Token) (interface{}, error) {
-		return rsaPublicKey, nil
+		kid, _ := token.Header["kid"].(string)
+		key, err := keySet.Key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		return key.keyFor(token)
+	}
+}
+
+func keyFuncForKey(key VerificationKey) func(token *jwt.Token) (interface{}, error) {
+	return func(token *jwt.Token) (interface{}, error) {
+		if kid, _ := token.Header["kid"].(string); key.Kid != "" && key.Kid != kid {
+			return nil, ErrUnknownKid
+		}
+		return key.keyFor(token)
 	}
 }
@@ -1,12 +1,18 @@
 package security
 
 import (
+	"context"
 	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/repository/logging/loggingtest"
 	"github.com/go-http-utils/headers"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 // private key was thrown away - if you need a new key for changes to the test cases, just have jwt.io roll you one
@@ -86,6 +92,116 @@ func TestJwtIdTokenValidatorMiddleware_JsonErrorToken_Reject(t *testing.T) {
 	tstJwtIdTokenTestcase(t, tstJwtInvalidJsonToken, false, false)
 }
 
+func TestJwtIdTokenValidatorMiddleware_ClaimValidation(t *testing.T) {
+	docs.Description("jwt middleware validates issuer, audience, required claims and time claims with leeway, and reports each rejection reason")
+
+	idp := tstNewIdp(t, "key1")
+	keySet, err := NewJwksKeySet(context.Background(), JwksKeySetOptions{JwksUrl: idp.server.URL + "/certs"})
+	require.Nil(t, err)
+
+	registry := prometheus.NewRegistry()
+	options := JwtIdTokenValidatorMiddlewareOptions{
+		KeySet:         keySet,
+		Issuers:        []string{"https://idp.example.com"},
+		Audiences:      []string{"room-service", "api"},
+		RequiredClaims: []string{"sub", "email"},
+		Leeway:         30 * time.Second,
+		Registerer:     registry,
+	}
+
+	now := Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   "https://idp.example.com",
+			"aud":   []string{"other", "api"},
+			"sub":   "1234567890",
+			"email": "john.doe@example.com",
+			"exp":   now.Add(time.Hour).Unix(),
+			"iat":   now.Unix(),
+		}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	testcases := []struct {
+		name   string
+		claims jwt.MapClaims
+		reason string
+	}{
+		{"valid", valid(), ""},
+		{"single audience", with("aud", "room-service"), ""},
+		{"expired within leeway", with("exp", now.Add(-20*time.Second).Unix()), ""},
+		{"expired", with("exp", now.Add(-time.Minute).Unix()), JwtRejectExpired},
+		{"not yet valid", with("nbf", now.Add(time.Minute).Unix()), JwtRejectNotYetValid},
+		{"issued in future", with("iat", now.Add(time.Minute).Unix()), JwtRejectIssuedInFuture},
+		{"issued in future within leeway", with("iat", now.Add(20*time.Second).Unix()), ""},
+		{"wrong issuer", with("iss", "https://evil.example.com"), JwtRejectIssuer},
+		{"wrong audience", with("aud", []string{"other"}), JwtRejectAudience},
+		{"no audience", with("aud", nil), JwtRejectAudience},
+		{"missing claim", with("email", nil), JwtRejectMissingClaim},
+		{"empty claim", with("sub", ""), JwtRejectMissingClaim},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := loggingtest.New(t)
+			r := httptest.NewRequest(http.MethodGet, "/v1/api", nil)
+			r.Header.Add(headers.Authorization, idp.tokenWithClaims(t, "key1", "key1", tc.claims))
+			r = r.WithContext(recorder.Context(r.Context()))
+			w := httptest.NewRecorder()
+			JwtIdTokenValidatorMiddleware(options)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+
+			if tc.reason == "" {
+				require.Equal(t, http.StatusNoContent, w.Code)
+				recorder.AssertNotLogged(loggingtest.Match{MessageContains: "unauthorized"})
+			} else {
+				require.Equal(t, http.StatusUnauthorized, w.Code)
+				recorder.RequireLogged(loggingtest.Match{Fields: map[string]string{ReasonFieldName: tc.reason}})
+			}
+		})
+	}
+
+	counter, err := registry.Gather()
+	require.Nil(t, err)
+	require.Len(t, counter, 1)
+	reasons := make(map[string]float64)
+	for _, metric := range counter[0].Metric {
+		reasons[metric.Label[0].GetValue()] = metric.Counter.GetValue()
+	}
+	require.Equal(t, map[string]float64{
+		JwtRejectExpired:        1,
+		JwtRejectNotYetValid:    1,
+		JwtRejectIssuedInFuture: 1,
+		JwtRejectIssuer:         1,
+		JwtRejectAudience:       2,
+		JwtRejectMissingClaim:   2,
+	}, reasons)
+}
+
+func TestJwtIdTokenValidatorMiddleware_SignatureReasons(t *testing.T) {
+	docs.Description("jwt middleware distinguishes malformed tokens, bad signatures and unknown keys")
+
+	idp := tstNewIdp(t, "key1", "key2")
+	keySet, err := NewJwksKeySet(context.Background(), JwksKeySetOptions{JwksUrl: idp.server.URL + "/certs"})
+	require.Nil(t, err)
+	options := JwtIdTokenValidatorMiddlewareOptions{KeySet: keySet}
+
+	_, reason, _ := parseJwt(context.Background(), strings.TrimPrefix(tstJwtInvalidB64Token, "Bearer "), options)
+	require.Equal(t, JwtRejectMalformed, reason)
+	_, reason, _ = parseJwt(context.Background(), strings.TrimPrefix(idp.token(t, "key1", "key2"), "Bearer "), options)
+	require.Equal(t, JwtRejectSignature, reason)
+	_, reason, _ = parseJwt(context.Background(), strings.TrimPrefix(idp.token(t, "key1", "unknown"), "Bearer "), options)
+	require.Equal(t, JwtRejectUnknownKey, reason)
+}
+
 // --- helpers ---
 
 func tstJwtIdTokenTestcase(t *testing.T, authorization string, shouldGoThrough bool, shouldAuthorize bool) {
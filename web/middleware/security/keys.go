@@ -0,0 +1,143 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrAlgorithmNotAllowed is returned when a token is signed with an algorithm that is not allowed for the key.
+var ErrAlgorithmNotAllowed = errors.New("signing algorithm not allowed for this key")
+
+// VerificationKey is a public key for validating token signatures, together with the algorithms it may be used with.
+type VerificationKey struct {
+	// Kid is optional for configured keys. If set, the key is only used for tokens with this kid.
+	Kid string
+	// Key is an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+	Key crypto.PublicKey
+	// Algorithms are the allowed signing algorithms, e.g. "RS256", "PS256", "ES256", "EdDSA".
+	//
+	// Defaults to the usual algorithm for the key type, see DefaultAlgorithms. Algorithms that do not
+	// fit the key type are never allowed, in particular "none" and the HMAC algorithms.
+	Algorithms []string
+}
+
+// DefaultAlgorithms returns the algorithms allowed for a key if none are configured.
+//
+// RSA keys default to RS256 only, because that is all we used to accept. Allow PS256 explicitly if needed.
+func DefaultAlgorithms(key crypto.PublicKey) []string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256"}
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return []string{"ES256"}
+		case elliptic.P384():
+			return []string{"ES384"}
+		case elliptic.P521():
+			return []string{"ES512"}
+		}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	}
+	return []string{}
+}
+
+// ParseVerificationKeysFromPEM parses RSA, ECDSA and Ed25519 public keys or certificates in PEM format,
+// allowing the default algorithms for each.
+func ParseVerificationKeysFromPEM(publicKeyPEMs []string) ([]VerificationKey, error) {
+	keys := make([]VerificationKey, 0)
+	for _, publicKeyPEM := range publicKeyPEMs {
+		key, err := ParseVerificationKeyFromPEM(publicKeyPEM)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ParseVerificationKeyFromPEM parses a public key or certificate in PEM format, allowing the given algorithms.
+//
+// Leave algorithms empty to allow the default algorithms for the key type.
+func ParseVerificationKeyFromPEM(publicKeyPEM string, algorithms ...string) (VerificationKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return VerificationKey{}, errors.New("invalid PEM: no PEM block found")
+	}
+
+	var key crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var certificate *x509.Certificate
+		certificate, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = certificate.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return VerificationKey{}, fmt.Errorf("invalid PEM: %w", err)
+	}
+	return NewVerificationKey(key, algorithms...)
+}
+
+// NewVerificationKey checks that the key type is supported and that the algorithms fit the key.
+func NewVerificationKey(key crypto.PublicKey, algorithms ...string) (VerificationKey, error) {
+	if len(DefaultAlgorithms(key)) == 0 {
+		return VerificationKey{}, fmt.Errorf("unsupported public key type %T", key)
+	}
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms(key)
+	}
+	for _, algorithm := range algorithms {
+		if !algorithmFitsKey(algorithm, key) {
+			return VerificationKey{}, fmt.Errorf("algorithm '%s' cannot be used with a key of type %T", algorithm, key)
+		}
+	}
+	return VerificationKey{Key: key, Algorithms: algorithms}, nil
+}
+
+// verificationKeysFromRSA converts the keys given as JwtIdTokenValidatorMiddlewareOptions.PublicKeys
+func verificationKeysFromRSA(rsaPublicKeys []*rsa.PublicKey) []VerificationKey {
+	keys := make([]VerificationKey, 0, len(rsaPublicKeys))
+	for _, key := range rsaPublicKeys {
+		keys = append(keys, VerificationKey{Key: key, Algorithms: DefaultAlgorithms(key)})
+	}
+	return keys
+}
+
+// keyFor is the jwt key func body: it returns the key if the token's algorithm is allowed for it
+func (k VerificationKey) keyFor(token *jwt.Token) (interface{}, error) {
+	algorithm := token.Method.Alg()
+	if !contains(k.Algorithms, algorithm) || !algorithmFitsKey(algorithm, k.Key) {
+		return nil, fmt.Errorf("%w: %s", ErrAlgorithmNotAllowed, algorithm)
+	}
+	return k.Key, nil
+}
+
+// algorithmFitsKey also protects against tokens with "none" or an HMAC algorithm that uses the public key as secret
+func algorithmFitsKey(algorithm string, key crypto.PublicKey) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return contains([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}, algorithm)
+	case *ecdsa.PublicKey:
+		return contains(DefaultAlgorithms(k), algorithm)
+	case ed25519.PublicKey:
+		return algorithm == "EdDSA"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestVerificationKeys_Algorithms(t *testing.T) {
+	docs.Description("jwt validation supports ES256, PS256 and EdDSA, with the allowed algorithms configured per key")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	ecVerificationKey, err := ParseVerificationKeyFromPEM(tstPublicKeyPEM(t, &ecKey.PublicKey))
+	require.Nil(t, err)
+	require.Equal(t, []string{"ES256"}, ecVerificationKey.Algorithms)
+	edVerificationKey, err := ParseVerificationKeyFromPEM(tstPublicKeyPEM(t, edPublicKey))
+	require.Nil(t, err)
+	rsaDefaultKey, err := ParseVerificationKeyFromPEM(tstPublicKeyPEM(t, &rsaKey.PublicKey))
+	require.Nil(t, err)
+	rsaPssKey, err := NewVerificationKey(&rsaKey.PublicKey, "PS256")
+	require.Nil(t, err)
+
+	testcases := []struct {
+		name   string
+		key    VerificationKey
+		method jwt.SigningMethod
+		signer crypto.PrivateKey
+		reason string
+	}{
+		{"ES256", ecVerificationKey, jwt.SigningMethodES256, ecKey, ""},
+		{"EdDSA", edVerificationKey, jwt.SigningMethodEdDSA, edKey, ""},
+		{"RS256 by default", rsaDefaultKey, jwt.SigningMethodRS256, rsaKey, ""},
+		{"PS256 if configured", rsaPssKey, jwt.SigningMethodPS256, rsaKey, ""},
+		{"PS256 not by default", rsaDefaultKey, jwt.SigningMethodPS256, rsaKey, JwtRejectAlgorithm},
+		{"RS256 not if PS256 configured", rsaPssKey, jwt.SigningMethodRS256, rsaKey, JwtRejectAlgorithm},
+		{"ES384 with P-256 key", ecVerificationKey, jwt.SigningMethodES384, tstMustGenerateEcKey(t, elliptic.P384()), JwtRejectAlgorithm},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			token := tstSignedToken(t, tc.method, tc.signer)
+			parsed, reason, _ := parseJwt(context.Background(), token, JwtIdTokenValidatorMiddlewareOptions{VerificationKeys: []VerificationKey{tc.key}})
+			require.Equal(t, tc.reason, reason)
+			require.Equal(t, tc.reason == "", parsed != nil)
+		})
+	}
+}
+
+func TestVerificationKeys_Confusion(t *testing.T) {
+	docs.Description("tokens with alg none, or signed with HMAC using the public key as secret, are always rejected")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	publicKeyPEM := tstPublicKeyPEM(t, &rsaKey.PublicKey)
+
+	// even a misconfiguration cannot allow these
+	_, err = ParseVerificationKeyFromPEM(publicKeyPEM, "HS256")
+	require.NotNil(t, err)
+	_, err = ParseVerificationKeyFromPEM(publicKeyPEM, "none")
+	require.NotNil(t, err)
+	misconfigured := VerificationKey{Key: &rsaKey.PublicKey, Algorithms: []string{"RS256", "HS256", "none"}}
+
+	hmacToken := tstSignedToken(t, jwt.SigningMethodHS256, []byte(publicKeyPEM))
+	noneToken := tstSignedToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType)
+
+	for _, token := range []string{hmacToken, noneToken} {
+		parsed, reason, _ := parseJwt(context.Background(), token, JwtIdTokenValidatorMiddlewareOptions{
+			PublicKeys:       []*rsa.PublicKey{&rsaKey.PublicKey},
+			VerificationKeys: []VerificationKey{misconfigured},
+		})
+		require.Nil(t, parsed)
+		require.Equal(t, JwtRejectAlgorithm, reason)
+	}
+}
+
+func TestParseJwk_KeyTypes(t *testing.T) {
+	docs.Description("JWKS keys of type EC and OKP are supported, and their alg restricts the allowed algorithm")
+
+	ecKey := tstMustGenerateEcKey(t, elliptic.P256())
+	key, err := parseJwk(jwk{
+		Kty: "EC",
+		Kid: "ec1",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+	})
+	require.Nil(t, err)
+	require.Equal(t, "ec1", key.Kid)
+	require.Equal(t, []string{"ES256"}, key.Algorithms)
+	require.True(t, ecKey.PublicKey.Equal(key.Key))
+
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	key, err = parseJwk(jwk{Kty: "OKP", Crv: "Ed25519", Alg: "EdDSA", X: base64.RawURLEncoding.EncodeToString(edPublicKey)})
+	require.Nil(t, err)
+	require.Equal(t, []string{"EdDSA"}, key.Algorithms)
+
+	_, err = parseJwk(jwk{Kty: "OKP", Crv: "Ed25519", Alg: "HS256", X: base64.RawURLEncoding.EncodeToString(edPublicKey)})
+	require.NotNil(t, err)
+	_, err = parseJwk(jwk{Kty: "oct", Alg: "HS256"})
+	require.NotNil(t, err)
+}
+
+// --- helpers ---
+
+func tstPublicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func tstMustGenerateEcKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	require.Nil(t, err)
+	return key
+}
+
+func tstSignedToken(t *testing.T, method jwt.SigningMethod, key interface{}) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"sub": "1234567890",
+		"exp": Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString(key)
+	require.Nil(t, err)
+	return signed
+}
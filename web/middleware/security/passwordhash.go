@@ -0,0 +1,84 @@
+package security
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var ErrUnsupportedPasswordHash = errors.New("unsupported password hash, must be bcrypt or argon2id")
+
+// ValidatePasswordHash checks that a password hash is in a supported format, so configuration errors show up at startup.
+//
+// Supported are bcrypt ($2a$, $2b$, $2y$) and argon2id in the PHC string format, e.g. as produced by
+// the argon2 command line tool: $argon2id$v=19$m=65536,t=3,p=4$<base64 salt>$<base64 hash>
+func ValidatePasswordHash(hash string) error {
+	if isBcryptHash(hash) {
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	}
+	_, err := parseArgon2idHash(hash)
+	return err
+}
+
+// VerifyPasswordHash checks a password against a bcrypt or argon2id hash. Invalid hashes never match.
+func VerifyPasswordHash(hash string, password string) bool {
+	if isBcryptHash(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	parsed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false
+	}
+	actual := argon2.IDKey([]byte(password), parsed.salt, parsed.time, parsed.memory, parsed.threads, uint32(len(parsed.key)))
+	return subtle.ConstantTimeCompare(parsed.key, actual) == 1
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2idHash(hash string) (argon2idHash, error) {
+	result := argon2idHash{}
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return result, ErrUnsupportedPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return result, fmt.Errorf("unsupported argon2id version '%s', must be v=%d", parts[2], argon2.Version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &result.memory, &result.time, &result.threads); err != nil {
+		return result, fmt.Errorf("invalid argon2id parameters '%s': %s", parts[3], err.Error())
+	}
+	if result.memory == 0 || result.time == 0 || result.threads == 0 {
+		return result, fmt.Errorf("invalid argon2id parameters '%s': must be positive", parts[3])
+	}
+
+	var err error
+	if result.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return result, fmt.Errorf("invalid argon2id salt: %s", err.Error())
+	}
+	if result.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return result, fmt.Errorf("invalid argon2id hash: %s", err.Error())
+	}
+	if len(result.key) == 0 {
+		return result, errors.New("invalid argon2id hash: empty")
+	}
+	return result, nil
+}
@@ -0,0 +1,174 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/StephanHCB/go-backend-service-common/api/apierrors"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Policy describes who may call the matching routes.
+//
+// All given requirements must be met. A policy without requirements lets every request through that
+// got past AuthRequiredMiddleware, so use it for routes on the AllowUnauthorized list.
+type Policy struct {
+	// Route is the method + url path combination this policy applies to, matched like
+	// AuthRequiredMiddlewareOptions.AllowUnauthorized. Path parameters can be written
+	// as in the chi route, they match a single path segment. The path is matched as chi routes it,
+	// so escaped characters such as %2F remain escaped.
+	//
+	// examples: "GET /v1/rooms/{id}", "(PUT|DELETE) /v1/rooms/{id}", "GET /swagger-ui.*"
+	Route string
+
+	// AnyOfGroups requires at least one of these groups
+	AnyOfGroups []string
+	// AllOfGroups requires all of these groups
+	AllOfGroups []string
+	// AnyOfRoles requires at least one of these roles
+	AnyOfRoles []string
+	// Scope requires this scope
+	Scope string
+}
+
+type AuthorizationPolicyMiddlewareOptions struct {
+	// Policies are checked in order, the first policy whose Route matches the request applies.
+	//
+	// Requests that match no policy are forbidden, see ReportUnmatchedRoutes. If the chi router has no
+	// route for such a request, it is passed on instead, so the router still answers with 404 or 405.
+	Policies []Policy
+}
+
+// AuthorizationPolicies enforces a list of policies, see AuthorizationPolicyMiddlewareOptions.
+type AuthorizationPolicies struct {
+	policies []compiledPolicy
+}
+
+type compiledPolicy struct {
+	Policy
+	route *regexp.Regexp
+}
+
+// pathParameter matches chi path parameters such as {id} or {id:[0-9]+}, but not regexp repetitions such as {2}
+var pathParameter = regexp.MustCompile(`\{[a-zA-Z_][a-zA-Z0-9_]*(:[^}]*)?}`)
+
+// NewAuthorizationPolicies compiles the policies. Fails on invalid route patterns, because skipping
+// a policy would silently forbid its routes.
+func NewAuthorizationPolicies(options AuthorizationPolicyMiddlewareOptions) (*AuthorizationPolicies, error) {
+	result := &AuthorizationPolicies{}
+	for _, policy := range options.Policies {
+		fullMatchPattern := "^" + pathParameter.ReplaceAllString(policy.Route, "[^/]+") + "$"
+		re, err := regexp.Compile(fullMatchPattern)
+		if err != nil {
+			return nil, fmt.Errorf("policy route pattern '%s' is invalid: %w", policy.Route, err)
+		}
+		result.policies = append(result.policies, compiledPolicy{Policy: policy, route: re})
+	}
+	return result, nil
+}
+
+func (p *AuthorizationPolicies) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		actualRequest := fmt.Sprintf("%s %s", r.Method, routingPath(r))
+		policy := p.match(actualRequest)
+		if policy == nil && !routeExists(r) {
+			// valid case, let the router answer with 404 or 405
+			next.ServeHTTP(w, r)
+			return
+		}
+		if policy == nil {
+			forbiddenErrorHandler(ctx, w, r, fmt.Sprintf("no authorization policy for %s", actualRequest))
+			return
+		}
+		if reason := policy.unmetRequirement(GetClaims(ctx)); reason != "" {
+			forbiddenErrorHandler(ctx, w, r, fmt.Sprintf("%s requires %s", actualRequest, reason))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ReportUnmatchedRoutes logs a warning for each route that no policy applies to, and returns them.
+//
+// Call this after all routes have been added to the router, requests to these routes will be forbidden.
+// If you use the standard middleware stack, middleware.VerifyRoutes does this for you.
+func (p *AuthorizationPolicies) ReportUnmatchedRoutes(ctx context.Context, routes chi.Routes) []string {
+	unmatched := make([]string, 0)
+	_ = chi.Walk(routes, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		// the route pattern itself is matched, path parameters such as {id} are matched by [^/]+ or .*
+		routeDescription := fmt.Sprintf("%s %s", method, strings.Replace(route, "/*/", "/", -1))
+		if p.match(routeDescription) == nil {
+			unmatched = append(unmatched, routeDescription)
+			aulogging.Logger.Ctx(ctx).Warn().Printf("no authorization policy for route %s - all requests will be forbidden", routeDescription)
+		}
+		return nil
+	})
+	return unmatched
+}
+
+// routingPath is the path chi routes on, which is the escaped path if it differs from the decoded one.
+//
+// Policies must be matched against the same path, or /v1/rooms/a%2Fb would match no policy
+// but still be routed to /v1/rooms/{id}.
+func routingPath(r *http.Request) string {
+	if r.URL.RawPath != "" {
+		return r.URL.RawPath
+	}
+	return r.URL.Path
+}
+
+// routeExists is true unless the chi router of the request definitely has no route for it
+func routeExists(r *http.Request) bool {
+	routeContext := chi.RouteContext(r.Context())
+	if routeContext == nil || routeContext.Routes == nil {
+		return true
+	}
+	path := routeContext.RoutePath
+	if path == "" {
+		path = routingPath(r)
+	}
+	return routeContext.Routes.Match(chi.NewRouteContext(), r.Method, path)
+}
+
+func (p *AuthorizationPolicies) match(request string) *compiledPolicy {
+	for i := range p.policies {
+		if p.policies[i].route.MatchString(request) {
+			return &p.policies[i]
+		}
+	}
+	return nil
+}
+
+// unmetRequirement describes the first requirement that is not met, or returns "" if all are met
+func (p *compiledPolicy) unmetRequirement(claims *AllClaims) string {
+	if claims == nil {
+		claims = &AllClaims{}
+	}
+	if len(p.AnyOfGroups) > 0 && !containsAny(claims.Groups, p.AnyOfGroups) {
+		return "any of groups " + strings.Join(p.AnyOfGroups, ", ")
+	}
+	for _, group := range p.AllOfGroups {
+		if !contains(claims.Groups, group) {
+			return "all of groups " + strings.Join(p.AllOfGroups, ", ")
+		}
+	}
+	if len(p.AnyOfRoles) > 0 && !containsAny(claims.Roles, p.AnyOfRoles) {
+		return "any of roles " + strings.Join(p.AnyOfRoles, ", ")
+	}
+	if p.Scope != "" && !contains(claims.Scopes, p.Scope) {
+		return "scope " + p.Scope
+	}
+	return ""
+}
+
+func forbiddenErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, logMessage string) {
+	aulogging.Logger.Ctx(ctx).Info().Printf("forbidden: %s", logMessage)
+	err := apierrors.NewForbiddenError("forbidden", "you are not authorized for this operation", nil, Now())
+	apierrors.HandleError(ctx, w, r, err, apierrors.IsForbiddenError)
+}
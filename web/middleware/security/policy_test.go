@@ -0,0 +1,136 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/StephanHCB/go-backend-service-common/api"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthorizationPolicies_Requirements(t *testing.T) {
+	docs.Description("the policy middleware checks the requirements of the first policy matching method and path")
+
+	cut, err := NewAuthorizationPolicies(tstPolicyOptions())
+	require.Nil(t, err)
+
+	user := &CustomClaims{Groups: []string{"users"}}
+	admin := &CustomClaims{Groups: []string{"users", "admins"}, Roles: []string{"room-admin"}, Scopes: []string{"rooms:write"}}
+	auditor := &CustomClaims{Groups: []string{"auditors", "admins"}}
+
+	testcases := []struct {
+		name    string
+		request string
+		claims  *CustomClaims
+		allowed bool
+	}{
+		{"anonymous on public route", "GET /health", nil, true},
+		{"any of groups", "GET /v1/rooms/17", user, true},
+		{"any of groups missing", "GET /v1/rooms/17", &CustomClaims{}, false},
+		{"anonymous on protected route", "GET /v1/rooms/17", nil, false},
+		{"scope and role", "PUT /v1/rooms/17", admin, true},
+		{"scope missing", "DELETE /v1/rooms/17", &CustomClaims{Groups: []string{"users"}, Roles: []string{"room-admin"}}, false},
+		{"all of groups", "GET /v1/audit", auditor, true},
+		{"all of groups, one missing", "GET /v1/audit", admin, false},
+		{"path parameter is one segment", "GET /v1/rooms/17/extra", admin, false},
+		{"no policy", "POST /v1/other", admin, false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			methodAndPath := strings.SplitN(tc.request, " ", 2)
+			request := httptest.NewRequest(methodAndPath[0], methodAndPath[1], nil)
+			if tc.claims != nil {
+				request = request.WithContext(PutClaims(request.Context(), &AllClaims{CustomClaims: *tc.claims}))
+			}
+			response := httptest.NewRecorder()
+			cut.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(response, request)
+
+			if tc.allowed {
+				require.Equal(t, http.StatusNoContent, response.Code)
+			} else {
+				require.Equal(t, http.StatusForbidden, response.Code)
+				errorDto := api.ErrorDto{}
+				require.Nil(t, json.Unmarshal(response.Body.Bytes(), &errorDto))
+				require.Equal(t, "forbidden", *errorDto.Message)
+			}
+		})
+	}
+}
+
+func TestAuthorizationPolicies_ReportUnmatchedRoutes(t *testing.T) {
+	docs.Description("routes without a matching policy are reported")
+
+	cut, err := NewAuthorizationPolicies(tstPolicyOptions())
+	require.Nil(t, err)
+
+	router := chi.NewRouter()
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	router.Get("/health", noop)
+	router.Get("/v1/rooms/{id}", noop)
+	router.Put("/v1/rooms/{id:[0-9]+}", noop)
+	router.Post("/v1/rooms", noop)
+	router.Route("/v1/audit", func(r chi.Router) {
+		r.Get("/", noop)
+		r.Delete("/", noop)
+	})
+
+	unmatched := cut.ReportUnmatchedRoutes(context.Background(), router)
+	require.ElementsMatch(t, []string{"POST /v1/rooms", "DELETE /v1/audit/"}, unmatched)
+}
+
+func TestAuthorizationPolicies_EncodedSlash(t *testing.T) {
+	docs.Description("policies are matched against the path the router uses, so an encoded slash cannot bypass them")
+
+	cut, err := NewAuthorizationPolicies(tstPolicyOptions())
+	require.Nil(t, err)
+
+	router := chi.NewRouter()
+	router.Use(cut.Middleware)
+	router.Get("/v1/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for path, status := range map[string]int{
+		"/v1/rooms/a":      http.StatusForbidden,
+		"/v1/rooms/a%2Fb":  http.StatusForbidden,
+		"/v1/other/a%2Fb":  http.StatusNotFound,
+		"/v1/rooms/a/%2Fb": http.StatusNotFound,
+	} {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, status, response.Code, path)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/rooms/a%2Fb", nil)
+	request = request.WithContext(PutClaims(request.Context(), &AllClaims{CustomClaims: CustomClaims{Groups: []string{"users"}}}))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	require.Equal(t, http.StatusNoContent, response.Code)
+}
+
+func TestAuthorizationPolicies_InvalidRoute(t *testing.T) {
+	docs.Description("an invalid policy route pattern is an error")
+
+	_, err := NewAuthorizationPolicies(AuthorizationPolicyMiddlewareOptions{Policies: []Policy{{Route: "GET /v1/(unclosed"}}})
+	require.NotNil(t, err)
+}
+
+// --- helpers ---
+
+func tstPolicyOptions() AuthorizationPolicyMiddlewareOptions {
+	return AuthorizationPolicyMiddlewareOptions{
+		Policies: []Policy{
+			{Route: "GET /health"},
+			{Route: "GET /v1/rooms/{id}", AnyOfGroups: []string{"users", "admins"}},
+			{Route: "(PUT|DELETE) /v1/rooms/{id}", AnyOfRoles: []string{"room-admin"}, Scope: "rooms:write"},
+			{Route: "GET /v1/audit/?", AllOfGroups: []string{"admins", "auditors"}},
+		},
+	}
+}
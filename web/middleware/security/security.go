@@ -3,6 +3,7 @@ package security
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/StephanHCB/go-backend-service-common/api"
 	"github.com/StephanHCB/go-backend-service-common/api/apierrors"
@@ -10,6 +11,7 @@ import (
 	"github.com/go-http-utils/headers"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -18,17 +20,26 @@ type ctxSecurityKeyType int
 const (
 	RawTokenKey ctxSecurityKeyType = 0
 	ClaimsKey   ctxSecurityKeyType = 1
+
+	// validatedBearerKey marks the bearer token that TokenIntrospection has already validated
+	validatedBearerKey ctxSecurityKeyType = 2
 )
 
+// CustomClaims is the normalized principal. For tokens, it is filled according to the ClaimMapping.
 type CustomClaims struct {
 	Name   string   `json:"name"`
 	Email  string   `json:"email"`
 	Groups []string `json:"groups"`
+	Roles  []string `json:"-"`
+	Scopes []string `json:"-"`
 }
 
 type AllClaims struct {
 	jwt.RegisteredClaims
 	CustomClaims
+
+	// Raw are all claims of the token, see RawClaims and Claim
+	Raw map[string]interface{} `json:"-"`
 }
 
 // Now exported for testing
@@ -78,6 +89,67 @@ func PutClaims(ctx context.Context, claimsPtr *AllClaims) context.Context {
 	return context.WithValue(ctx, ClaimsKey, claimsPtr)
 }
 
+// putValidatedBearer records that the bearer token was validated, so the JwtIdTokenValidatorMiddleware
+// leaves it alone. Claims alone are not enough, any middleware may put them.
+func putValidatedBearer(ctx context.Context, tokenString string) context.Context {
+	return context.WithValue(ctx, validatedBearerKey, tokenString)
+}
+
+func isValidatedBearer(ctx context.Context, tokenString string) bool {
+	validated, ok := ctx.Value(validatedBearerKey).(string)
+	return ok && validated == tokenString
+}
+
+// copy returns a deep copy, so callers may modify the claims without affecting others sharing the original
+func (c *AllClaims) copy() *AllClaims {
+	result := *c
+	result.Audience = copySlice(c.Audience)
+	result.ExpiresAt = copyDate(c.ExpiresAt)
+	result.NotBefore = copyDate(c.NotBefore)
+	result.IssuedAt = copyDate(c.IssuedAt)
+	result.Groups = copySlice(c.Groups)
+	result.Roles = copySlice(c.Roles)
+	result.Scopes = copySlice(c.Scopes)
+	if c.Raw != nil {
+		result.Raw = copyJsonValue(c.Raw).(map[string]interface{})
+	}
+	return &result
+}
+
+func copySlice[T any](values []T) []T {
+	if values == nil {
+		return nil
+	}
+	return append(make([]T, 0, len(values)), values...)
+}
+
+func copyDate(date *jwt.NumericDate) *jwt.NumericDate {
+	if date == nil {
+		return nil
+	}
+	result := *date
+	return &result
+}
+
+func copyJsonValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			result[k] = copyJsonValue(v)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for i, v := range typed {
+			result[i] = copyJsonValue(v)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
 func IsAuthenticated(ctx context.Context, logMessage string, timestamp time.Time) apierrors.AnnotatedError {
 	claimsPtr := GetClaims(ctx)
 	if claimsPtr == nil {
@@ -103,6 +175,26 @@ func HasGroup(ctx context.Context, group string, logMessage string, timestamp ti
 	return nil
 }
 
+func HasRole(ctx context.Context, role string, logMessage string, timestamp time.Time) apierrors.AnnotatedError {
+	return hasValue(ctx, func(claims *AllClaims) []string { return claims.Roles }, role, logMessage, timestamp)
+}
+
+func HasScope(ctx context.Context, scope string, logMessage string, timestamp time.Time) apierrors.AnnotatedError {
+	return hasValue(ctx, func(claims *AllClaims) []string { return claims.Scopes }, scope, logMessage, timestamp)
+}
+
+func hasValue(ctx context.Context, values func(claims *AllClaims) []string, value string, logMessage string, timestamp time.Time) apierrors.AnnotatedError {
+	if value == "" {
+		return nil
+	}
+	claimsPtr := GetClaims(ctx)
+	if claimsPtr == nil || !contains(values(claimsPtr), value) {
+		aulogging.Logger.Ctx(ctx).Info().Printf("forbidden: %s", logMessage)
+		return apierrors.NewForbiddenError("forbidden", "you are not authorized for this operation", nil, timestamp)
+	}
+	return nil
+}
+
 func Name(ctx context.Context) string {
 	claimsPtr := GetClaims(ctx)
 	if claimsPtr == nil {
@@ -127,6 +219,22 @@ func Subject(ctx context.Context) string {
 	return claimsPtr.RegisteredClaims.Subject
 }
 
+func Roles(ctx context.Context) []string {
+	claimsPtr := GetClaims(ctx)
+	if claimsPtr == nil {
+		return nil
+	}
+	return claimsPtr.Roles
+}
+
+func Scopes(ctx context.Context) []string {
+	claimsPtr := GetClaims(ctx)
+	if claimsPtr == nil {
+		return nil
+	}
+	return claimsPtr.Scopes
+}
+
 func contains(haystack []string, needle string) bool {
 	if len(haystack) == 0 {
 		return false
@@ -139,13 +247,39 @@ func contains(haystack []string, needle string) bool {
 	return false
 }
 
+// decodeRawClaims returns all claims of a token whose signature has already been verified
+func decodeRawClaims(rawToken string) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token contains an invalid number of segments")
+	}
+	payload, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	rawClaims := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &rawClaims); err != nil {
+		return nil, err
+	}
+	return rawClaims, nil
+}
+
 // error handlers
 
+// ReasonFieldName is the log field for the reason why authentication failed
+const ReasonFieldName = "event.reason"
+
 func unauthorizedErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, logMessage string, timeStamp time.Time) {
 	aulogging.Logger.Ctx(ctx).Info().Printf("unauthorized: %s", logMessage)
 	errorHandler(ctx, w, r, "unauthorized", http.StatusUnauthorized, "missing or invalid Authorization header (JWT bearer token expected) or token invalid or expired", timeStamp)
 }
 
+// rejectedErrorHandler is like unauthorizedErrorHandler, but also logs the reason as a separate field for filtering
+func rejectedErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, reason string, logMessage string, timeStamp time.Time) {
+	aulogging.Logger.Ctx(ctx).Info().With(ReasonFieldName, reason).Printf("unauthorized: %s: %s", reason, logMessage)
+	errorHandler(ctx, w, r, "unauthorized", http.StatusUnauthorized, "missing or invalid Authorization header (JWT bearer token expected) or token invalid or expired", timeStamp)
+}
+
 func errorHandler(ctx context.Context, w http.ResponseWriter, _ *http.Request, msg string, status int, details string, timestamp time.Time) {
 	detailsPtr := &details
 	if details == "" {
@@ -0,0 +1,357 @@
+// Package securitytest generates keys and mints tokens for tests of code that uses the security middlewares.
+//
+// For handler tests, put claims into the context directly with NewContext().
+//
+// For full stack tests, create a KeyPair, configure the middleware stack with its VerificationKey() or
+// PublicKeyPEM(), or serve it as JWKS with NewIssuer(), and send requests with an Authorization header from Bearer().
+package securitytest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/StephanHCB/go-backend-service-common/web/middleware/security"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// KeyPair is a signing key for one algorithm, e.g. "RS256", "PS256", "ES256", "ES384", "ES512" or "EdDSA".
+type KeyPair struct {
+	t testing.TB
+
+	Kid        string
+	Algorithm  string
+	PrivateKey crypto.Signer
+}
+
+// NewKeyPair generates a key pair for the algorithm. The kid is put into the header of minted tokens.
+func NewKeyPair(t testing.TB, kid string, algorithm string) *KeyPair {
+	t.Helper()
+
+	var key crypto.Signer
+	var err error
+	switch {
+	case strings.HasPrefix(algorithm, "RS") || strings.HasPrefix(algorithm, "PS"):
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case algorithm == "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algorithm == "ES384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case algorithm == "ES512":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case algorithm == "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("securitytest: unsupported algorithm %s", algorithm)
+	}
+	if err != nil {
+		t.Fatalf("securitytest: failed to generate %s key: %s", algorithm, err.Error())
+	}
+	return &KeyPair{t: t, Kid: kid, Algorithm: algorithm, PrivateKey: key}
+}
+
+func (k *KeyPair) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// PublicKeyPEM is the public key in the format expected by JwtPublicKeyPEMs of the middleware stack.
+func (k *KeyPair) PublicKeyPEM() string {
+	k.t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(k.PublicKey())
+	if err != nil {
+		k.t.Fatalf("securitytest: failed to marshal public key: %s", err.Error())
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// VerificationKey allows exactly the algorithm of the key pair.
+func (k *KeyPair) VerificationKey() security.VerificationKey {
+	k.t.Helper()
+	key, err := security.NewVerificationKey(k.PublicKey(), k.Algorithm)
+	if err != nil {
+		k.t.Fatalf("securitytest: %s", err.Error())
+	}
+	key.Kid = k.Kid
+	return key
+}
+
+// Jwk is the public key as a JSON Web Key.
+func (k *KeyPair) Jwk() map[string]interface{} {
+	result := map[string]interface{}{"kid": k.Kid, "alg": k.Algorithm, "use": "sig"}
+	switch key := k.PublicKey().(type) {
+	case *rsa.PublicKey:
+		result["kty"] = "RSA"
+		result["n"] = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		result["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		result["kty"] = "EC"
+		result["crv"] = key.Curve.Params().Name
+		result["x"] = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		result["y"] = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		result["kty"] = "OKP"
+		result["crv"] = "Ed25519"
+		result["x"] = base64.RawURLEncoding.EncodeToString(key)
+	}
+	return result
+}
+
+// Jwks is the JSON Web Key Set of the public keys.
+func Jwks(t testing.TB, keys ...*KeyPair) []byte {
+	t.Helper()
+	result, err := jwks(keys)
+	if err != nil {
+		t.Fatalf("securitytest: failed to marshal jwks: %s", err.Error())
+	}
+	return result
+}
+
+func jwks(keys []*KeyPair) ([]byte, error) {
+	jwkList := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		jwkList = append(jwkList, key.Jwk())
+	}
+	return json.Marshal(map[string]interface{}{"keys": jwkList})
+}
+
+// Token describes a token to mint. Leave fields empty to omit the claim.
+type Token struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+
+	Name   string
+	Email  string
+	Groups []string
+	Roles  []string
+	// Scope is the space separated scope claim
+	Scope string
+
+	// ExpiresIn is relative to security.Now(), default 1 hour. Make it negative for an expired token.
+	ExpiresIn time.Duration
+	// IssuedAt defaults to security.Now()
+	IssuedAt time.Time
+
+	// Claims are added last, so they can also replace or, with a nil value, remove the claims above
+	Claims map[string]interface{}
+
+	// Algorithm defaults to the algorithm of the key pair. Set it to test algorithm restrictions,
+	// e.g. PS256 with an RS256 key pair.
+	Algorithm string
+}
+
+// Mint signs the token.
+func (k *KeyPair) Mint(token Token) string {
+	k.t.Helper()
+
+	now := security.Now()
+	if token.IssuedAt.IsZero() {
+		token.IssuedAt = now
+	}
+	if token.ExpiresIn == 0 {
+		token.ExpiresIn = time.Hour
+	}
+	claims := jwt.MapClaims{
+		"iat": token.IssuedAt.Unix(),
+		"exp": now.Add(token.ExpiresIn).Unix(),
+	}
+	setIfNotEmpty(claims, "iss", token.Issuer)
+	setIfNotEmpty(claims, "sub", token.Subject)
+	setIfNotEmpty(claims, "name", token.Name)
+	setIfNotEmpty(claims, "email", token.Email)
+	setIfNotEmpty(claims, "scope", token.Scope)
+	if len(token.Audience) > 0 {
+		claims["aud"] = token.Audience
+	}
+	if len(token.Groups) > 0 {
+		claims["groups"] = token.Groups
+	}
+	if len(token.Roles) > 0 {
+		claims["roles"] = token.Roles
+	}
+	for name, value := range token.Claims {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+
+	algorithm := token.Algorithm
+	if algorithm == "" {
+		algorithm = k.Algorithm
+	}
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		k.t.Fatalf("securitytest: unsupported algorithm %s", algorithm)
+	}
+	unsigned := jwt.NewWithClaims(method, claims)
+	if k.Kid != "" {
+		unsigned.Header["kid"] = k.Kid
+	}
+	signed, err := unsigned.SignedString(k.PrivateKey)
+	if err != nil {
+		k.t.Fatalf("securitytest: failed to sign token: %s", err.Error())
+	}
+	return signed
+}
+
+// Bearer is the value for an Authorization header with the minted token.
+func (k *KeyPair) Bearer(token Token) string {
+	k.t.Helper()
+	return "Bearer " + k.Mint(token)
+}
+
+func setIfNotEmpty(claims jwt.MapClaims, name string, value string) {
+	if value != "" {
+		claims[name] = value
+	}
+}
+
+// Issuer serves the keys at /certs, and the OpenID discovery document, for use with
+// JwksUrl or JwksDiscoveryUrl of the middleware stack. The server is closed when the test ends.
+//
+// Use Rotate to change the served keys, e.g. to test key rotation.
+type Issuer struct {
+	Url string
+
+	lock sync.RWMutex
+	keys []*KeyPair
+}
+
+func NewIssuer(t testing.TB, keys ...*KeyPair) *Issuer {
+	t.Helper()
+	issuer := &Issuer{keys: keys}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.Url, "jwks_uri": issuer.JwksUrl()})
+		case "/certs":
+			// not Jwks, t.Fatalf must not be called outside the test goroutine
+			body, err := jwks(issuer.Keys())
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	issuer.Url = server.URL
+	return issuer
+}
+
+func (i *Issuer) JwksUrl() string {
+	return i.Url + "/certs"
+}
+
+// Keys returns the keys currently served.
+func (i *Issuer) Keys() []*KeyPair {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return append([]*KeyPair(nil), i.keys...)
+}
+
+// Rotate replaces the served keys. It is safe to call while the middleware fetches them.
+func (i *Issuer) Rotate(keys ...*KeyPair) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.keys = append([]*KeyPair(nil), keys...)
+}
+
+// ContextBuilder puts claims into a context, as the authentication middlewares do.
+type ContextBuilder struct {
+	claims   security.AllClaims
+	rawToken string
+}
+
+// NewContext starts a context builder, e.g.
+//
+//	ctx := securitytest.NewContext().WithSubject("1234").WithGroups("admins").Build(context.Background())
+func NewContext() *ContextBuilder {
+	return &ContextBuilder{claims: security.AllClaims{Raw: make(map[string]interface{})}}
+}
+
+func (b *ContextBuilder) WithSubject(subject string) *ContextBuilder {
+	b.claims.Subject = subject
+	b.claims.Raw["sub"] = subject
+	return b
+}
+
+func (b *ContextBuilder) WithName(name string) *ContextBuilder {
+	b.claims.Name = name
+	b.claims.Raw["name"] = name
+	return b
+}
+
+func (b *ContextBuilder) WithEmail(email string) *ContextBuilder {
+	b.claims.Email = email
+	b.claims.Raw["email"] = email
+	return b
+}
+
+func (b *ContextBuilder) WithGroups(groups ...string) *ContextBuilder {
+	b.claims.Groups = append(b.claims.Groups, groups...)
+	b.claims.Raw["groups"] = b.claims.Groups
+	return b
+}
+
+func (b *ContextBuilder) WithRoles(roles ...string) *ContextBuilder {
+	b.claims.Roles = append(b.claims.Roles, roles...)
+	b.claims.Raw["roles"] = b.claims.Roles
+	return b
+}
+
+func (b *ContextBuilder) WithScopes(scopes ...string) *ContextBuilder {
+	b.claims.Scopes = append(b.claims.Scopes, scopes...)
+	b.claims.Raw["scope"] = strings.Join(b.claims.Scopes, " ")
+	return b
+}
+
+// WithClaim sets a raw claim, available through security.RawClaims and security.Claim
+func (b *ContextBuilder) WithClaim(name string, value interface{}) *ContextBuilder {
+	b.claims.Raw[name] = value
+	return b
+}
+
+// WithRawToken sets the raw token, e.g. for code that forwards it to other services
+func (b *ContextBuilder) WithRawToken(rawToken string) *ContextBuilder {
+	b.rawToken = rawToken
+	return b
+}
+
+// Build returns a child of ctx with the claims.
+func (b *ContextBuilder) Build(ctx context.Context) context.Context {
+	claims := b.claims
+	claims.Raw = make(map[string]interface{}, len(b.claims.Raw))
+	for name, value := range b.claims.Raw {
+		claims.Raw[name] = value
+	}
+	if b.rawToken != "" {
+		ctx = security.PutRawToken(ctx, b.rawToken)
+	}
+	return security.PutClaims(ctx, &claims)
+}
+
+// Request returns a copy of r whose context has the claims.
+func (b *ContextBuilder) Request(r *http.Request) *http.Request {
+	return r.WithContext(b.Build(r.Context()))
+}
@@ -0,0 +1,150 @@
+package securitytest
+
+import (
+	"context"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/web/middleware/security"
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKeyPair_Algorithms(t *testing.T) {
+	docs.Description("minted tokens of all supported algorithms are accepted by the jwt middleware, with PEM or JWKS keys")
+
+	for _, algorithm := range []string{"RS256", "PS256", "ES256", "ES384", "ES512", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			cut := NewKeyPair(t, "key-"+algorithm, algorithm)
+
+			pemKey, err := security.ParseVerificationKeyFromPEM(cut.PublicKeyPEM(), algorithm)
+			require.Nil(t, err)
+			claims := tstAuthenticate(t, security.JwtIdTokenValidatorMiddlewareOptions{VerificationKeys: []security.VerificationKey{pemKey}},
+				cut.Bearer(Token{Subject: "1234", Groups: []string{"admins"}}))
+			require.NotNil(t, claims)
+			require.Equal(t, "1234", claims.Subject)
+			require.Equal(t, []string{"admins"}, claims.Groups)
+
+			keySet, err := security.NewJwksKeySet(context.Background(), security.JwksKeySetOptions{JwksUrl: NewIssuer(t, cut).JwksUrl()})
+			require.Nil(t, err)
+			require.NotNil(t, tstAuthenticate(t, security.JwtIdTokenValidatorMiddlewareOptions{KeySet: keySet}, cut.Bearer(Token{})))
+		})
+	}
+}
+
+func TestKeyPair_Mint(t *testing.T) {
+	docs.Description("minted tokens carry the given claims, expiry and algorithm")
+
+	cut := NewKeyPair(t, "key1", "RS256")
+	issuer := NewIssuer(t, cut)
+	keySet, err := security.NewJwksKeySet(context.Background(), security.JwksKeySetOptions{DiscoveryUrl: issuer.Url})
+	require.Nil(t, err)
+	options := security.JwtIdTokenValidatorMiddlewareOptions{
+		KeySet:    keySet,
+		Issuers:   []string{issuer.Url},
+		Audiences: []string{"room-service"},
+	}
+
+	claims := tstAuthenticate(t, options, cut.Bearer(Token{
+		Issuer:   issuer.Url,
+		Audience: []string{"room-service"},
+		Name:     "John Doe",
+		Email:    "john.doe@example.com",
+		Roles:    []string{"room-admin"},
+		Scope:    "rooms:read rooms:write",
+		Claims:   map[string]interface{}{"tenant": "acme"},
+	}))
+	require.NotNil(t, claims)
+	require.Equal(t, "John Doe", claims.Name)
+	require.Equal(t, "john.doe@example.com", claims.Email)
+	require.Equal(t, []string{"room-admin"}, claims.Roles)
+	require.Equal(t, []string{"rooms:read", "rooms:write"}, claims.Scopes)
+	require.Equal(t, "acme", claims.Raw["tenant"])
+
+	for name, token := range map[string]Token{
+		"expired":         {Issuer: issuer.Url, Audience: []string{"room-service"}, ExpiresIn: -time.Minute},
+		"wrong audience":  {Issuer: issuer.Url, Audience: []string{"other"}},
+		"removed issuer":  {Issuer: issuer.Url, Audience: []string{"room-service"}, Claims: map[string]interface{}{"iss": nil}},
+		"other algorithm": {Issuer: issuer.Url, Audience: []string{"room-service"}, Algorithm: "PS256"},
+	} {
+		require.Nil(t, tstAuthenticate(t, options, cut.Bearer(token)), name)
+	}
+}
+
+func TestIssuer_Rotate(t *testing.T) {
+	docs.Description("the issuer serves rotated keys, also while the key set is fetching them")
+
+	key1 := NewKeyPair(t, "key1", "ES256")
+	key2 := NewKeyPair(t, "key2", "ES256")
+	issuer := NewIssuer(t, key1)
+	keySet, err := security.NewJwksKeySet(context.Background(), security.JwksKeySetOptions{JwksUrl: issuer.JwksUrl(), MinRefreshInterval: time.Nanosecond})
+	require.Nil(t, err)
+	options := security.JwtIdTokenValidatorMiddlewareOptions{KeySet: keySet}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for j := 0; j < 20; j++ {
+			issuer.Rotate(key1, key2)
+			issuer.Rotate(key1)
+		}
+	}()
+	for j := 0; j < 20; j++ {
+		_ = tstAuthenticate(t, options, key2.Bearer(Token{}))
+	}
+	<-done
+
+	issuer.Rotate(key1, key2)
+	require.Equal(t, []*KeyPair{key1, key2}, issuer.Keys())
+	require.NotNil(t, tstAuthenticate(t, options, key2.Bearer(Token{})))
+}
+
+func TestNewContext(t *testing.T) {
+	docs.Description("the context builder injects claims for handler tests")
+
+	ctx := NewContext().
+		WithSubject("1234").
+		WithName("John Doe").
+		WithEmail("john.doe@example.com").
+		WithGroups("users", "admins").
+		WithRoles("room-admin").
+		WithScopes("rooms:write").
+		WithClaim("tenant", "acme").
+		WithRawToken("raw-token").
+		Build(context.Background())
+
+	require.Equal(t, "1234", security.Subject(ctx))
+	require.Equal(t, "John Doe", security.Name(ctx))
+	require.Equal(t, "john.doe@example.com", security.Email(ctx))
+	require.Nil(t, security.HasGroup(ctx, "admins", "", time.Now()))
+	require.Nil(t, security.HasRole(ctx, "room-admin", "", time.Now()))
+	require.Nil(t, security.HasScope(ctx, "rooms:write", "", time.Now()))
+	require.NotNil(t, security.HasScope(ctx, "rooms:delete", "", time.Now()))
+	tenant, ok := security.Claim(ctx, "tenant")
+	require.True(t, ok)
+	require.Equal(t, "acme", tenant)
+	require.Equal(t, "raw-token", security.GetRawToken(ctx))
+
+	r := NewContext().WithGroups("users").Request(httptest.NewRequest(http.MethodGet, "/v1/api", nil))
+	require.Nil(t, security.IsAuthenticated(r.Context(), "", time.Now()))
+}
+
+// --- helpers ---
+
+// tstAuthenticate returns the claims if the jwt middleware accepts the authorization, nil otherwise
+func tstAuthenticate(t *testing.T, options security.JwtIdTokenValidatorMiddlewareOptions, authorization string) *security.AllClaims {
+	var claims *security.AllClaims
+	r := httptest.NewRequest(http.MethodGet, "/v1/api", nil)
+	r.Header.Set(headers.Authorization, authorization)
+	w := httptest.NewRecorder()
+	security.JwtIdTokenValidatorMiddleware(options)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims = security.GetClaims(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(w, r)
+	if claims == nil {
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	return claims
+}
@@ -2,9 +2,11 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/StephanHCB/go-autumn-logging-zerolog/loggermiddleware"
 	auapmmiddleware "github.com/StephanHCB/go-autumn-restclient-apm/implementation/middleware"
+	"github.com/StephanHCB/go-backend-service-common/acorns/repository"
 	"github.com/StephanHCB/go-backend-service-common/web/middleware/apmtracing"
 	"github.com/StephanHCB/go-backend-service-common/web/middleware/cancellogger"
 	"github.com/StephanHCB/go-backend-service-common/web/middleware/corsheader"
@@ -16,7 +18,8 @@ import (
 	"github.com/StephanHCB/go-backend-service-common/web/middleware/security"
 	"github.com/StephanHCB/go-backend-service-common/web/middleware/timeout"
 	"github.com/go-chi/chi/v5"
-	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
 )
 
 type MiddlewareStackOptions struct {
@@ -24,23 +27,66 @@ type MiddlewareStackOptions struct {
 	PlainLogging      bool
 
 	CorsAllowOrigin string // set to enable
+	// set instead of CorsAllowOrigin to pick up changes from configuration reloads
+	CorsConfiguration repository.Configuration
 
 	RequestTimeoutSeconds int // set >0 to enable
 
 	HasJwtIdTokenAuthorization bool
-	JwtPublicKeyPEMs           []string
+	// JwtPublicKeyPEMs are RSA, ECDSA or Ed25519 public keys, allowing RS256, ES256/ES384/ES512 or EdDSA respectively
+	JwtPublicKeyPEMs []string
+	// JwtVerificationKeys are additional keys with explicitly allowed algorithms, e.g. to allow PS256 for an RSA key
+	JwtVerificationKeys []security.VerificationKey
+	// JwksUrl or JwksDiscoveryUrl make the JWT validation fetch the keys from the identity provider,
+	// selected by kid and refreshed periodically, so key rotations need no redeploy.
+	//
+	// Can be combined with JwtPublicKeyPEMs, which are then used for tokens with an unknown kid.
+	JwksUrl string
+	// JwksDiscoveryUrl is the issuer url or OIDC discovery url, used to look up the jwks_uri if JwksUrl is empty
+	JwksDiscoveryUrl string
+	// JwksRefreshInterval defaults to 1 hour
+	JwksRefreshInterval time.Duration
+	// JwtIssuers, JwtAudiences, JwtRequiredClaims and JwtLeeway restrict the accepted tokens,
+	// see security.JwtIdTokenValidatorMiddlewareOptions
+	JwtIssuers        []string
+	JwtAudiences      []string
+	JwtRequiredClaims []string
+	JwtLeeway         time.Duration
+	// JwtClaimMapping configures where name, email, groups, roles and scopes are taken from in the token
+	JwtClaimMapping security.ClaimMapping
 
 	// support a fixed basic auth setup for use by e.g. CI systems
 	//
 	// can be used both in addition to HasJwtAuthorization and standalone
 	//
-	// to enable, set HasBasicAuthAuthorization and provide nonempty username and password from
+	// to enable, set HasBasicAuthAuthorization and provide nonempty username and password, or BasicAuthUsers, from
 	// configuration. When the authorization header matches, the injected user will then have
 	// the CustomClaims provided here set in the request context.
 	HasBasicAuthAuthorization bool
 	BasicAuthUsername         string
 	BasicAuthPassword         string
 	BasicAuthClaims           security.CustomClaims
+	// BasicAuthUsers are additional users with bcrypt or argon2id password hashes and their own claims,
+	// typically from Configuration.BasicAuthUsers(). Invalid hashes cause setup to fail.
+	BasicAuthUsers []repository.BasicAuthUser
+
+	// support api keys for machine clients that cannot do OAuth
+	//
+	// to enable, set HasApiKeyAuthorization and provide an ApiKeyStore, e.g. security.NewConfigApiKeyStore
+	// with Configuration.ApiKeys(), or security.NewVaultApiKeyStore. When the ApiKeyHeader
+	// (default X-API-Key) contains a known key, the claims of the key are set in the request context.
+	HasApiKeyAuthorization bool
+	ApiKeyHeader           string
+	ApiKeyStore            security.ApiKeyStore
+
+	// TokenIntrospection validates opaque bearer tokens at the authorization server, see security.NewTokenIntrospection.
+	//
+	// Can be combined with HasJwtIdTokenAuthorization, tokens that look like a JWT are then left to the JWT validation.
+	TokenIntrospection *security.TokenIntrospection
+
+	// ClientCertAuthentication authenticates callers by client certificate, from TLS connections or
+	// forwarded by a trusted proxy, see security.NewClientCertAuthentication.
+	ClientCertAuthentication *security.ClientCertAuthentication
 
 	DisableSecurityEnforcement bool
 	// AllowUnauthorized is the explicit list of method + url path combinations that allow unauthorized access.
@@ -51,13 +97,21 @@ type MiddlewareStackOptions struct {
 	//
 	// examples: "PUT /v1/info", "GET /swagger-ui.*" (regexp supported)
 	AllowUnauthorized []string
+	// AuthorizationPolicies are enforced after authentication, see security.NewAuthorizationPolicies.
+	//
+	// Call VerifyRoutes after all controllers are wired up, so routes without a policy are reported.
+	AuthorizationPolicies *security.AuthorizationPolicies
 
-	// SkipDuplicateSetup must be set to true if you are trying to set up a second middleware stack
+	// SkipDuplicateSetup is no longer needed to set up a second middleware stack, and is ignored.
 	//
-	// Some setup cannot be run multiple times, e.g. metrics registration panics on duplicates
+	// Deprecated: all setup can now be run multiple times.
 	SkipDuplicateSetup bool
 
+	// MetricsRegisterer is used for the request and security metrics, leave nil to use metrics.Registerer
+	MetricsRegisterer prometheus.Registerer
+
 	RequestLoggingOptions requestlogging.Options
+	RequestMetricsOptions requestmetrics.Options
 }
 
 func SetupStandardMiddlewareStack(ctx context.Context, router chi.Router, options MiddlewareStackOptions) error {
@@ -70,13 +124,8 @@ func SetupStandardMiddlewareStack(ctx context.Context, router chi.Router, option
 		ElasticApmEnabled: options.ElasticApmEnabled,
 		PlainLogging:      options.PlainLogging,
 	}
-	if options.SkipDuplicateSetup {
-		tracingMiddleware := apmtracing.GetApmMiddlewareAfterSetup(ctx, tracingOptions)
-		router.Use(tracingMiddleware)
-	} else {
-		tracingMiddleware := apmtracing.BuildApmMiddleware(ctx, tracingOptions)
-		router.Use(tracingMiddleware)
-	}
+	tracingMiddleware := apmtracing.BuildApmMiddleware(ctx, tracingOptions)
+	router.Use(tracingMiddleware)
 	router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("ElasticApm"))
 
 	loggerOptions := apmtracing.ConfigureContextLoggingForApm(ctx, tracingOptions)
@@ -85,10 +134,7 @@ func SetupStandardMiddlewareStack(ctx context.Context, router chi.Router, option
 	router.Use(loggermiddleware.AddZerologLoggerToContextMiddleware(loggerOptions))
 	router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("AddZerologLogger"))
 
-	if !options.SkipDuplicateSetup {
-		requestlogging.Setup(options.RequestLoggingOptions)
-	}
-	router.Use(chimiddleware.Logger)
+	router.Use(requestlogging.Middleware(options.RequestLoggingOptions))
 	router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("Logger"))
 
 	router.Use(recoverer.PanicRecoverer)
@@ -100,37 +146,86 @@ func SetupStandardMiddlewareStack(ctx context.Context, router chi.Router, option
 	router.Use(auapmmiddleware.AddTraceHeadersToResponse)
 	router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("AddTraceHeadersToResponse"))
 
-	router.Use(corsheader.CorsHandlingWithCorsAllowOrigin(options.CorsAllowOrigin))
+	if options.CorsConfiguration != nil {
+		router.Use(corsheader.CorsHandlingWithConfig(options.CorsConfiguration))
+	} else {
+		router.Use(corsheader.CorsHandlingWithCorsAllowOrigin(options.CorsAllowOrigin))
+	}
 	router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("CorsHandling"))
 
-	if !options.SkipDuplicateSetup {
-		requestmetrics.Setup() // panics if called twice
-	}
-	router.Use(requestmetrics.RecordRequestMetrics)
+	router.Use(requestmetrics.New(options.MetricsRegisterer, options.RequestMetricsOptions).Middleware)
 	router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("RecordRequestMetrics"))
 
+	if options.TokenIntrospection != nil {
+		router.Use(options.TokenIntrospection.Middleware)
+		router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("TokenIntrospection"))
+	}
 	if options.HasJwtIdTokenAuthorization {
-		rsaKeys, err := security.ParsePublicKeysFromPEM(options.JwtPublicKeyPEMs)
+		if err := options.JwtClaimMapping.Validate(); err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("Invalid JWT claim mapping - bailing out: %s", err.Error())
+			return err
+		}
+		verificationKeys, err := security.ParseVerificationKeysFromPEM(options.JwtPublicKeyPEMs)
 		if err != nil {
 			// breaking because the service probably will not work correctly without its key set anyway
 			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("Failed to parse PEM public keys for JWT validation - bailing out: %s", err.Error())
 			return err
 		}
 		jwtOptions := security.JwtIdTokenValidatorMiddlewareOptions{
-			PublicKeys: rsaKeys,
+			VerificationKeys: append(verificationKeys, options.JwtVerificationKeys...),
+			Issuers:          options.JwtIssuers,
+			Audiences:        options.JwtAudiences,
+			RequiredClaims:   options.JwtRequiredClaims,
+			Leeway:           options.JwtLeeway,
+			ClaimMapping:     options.JwtClaimMapping,
+			Registerer:       options.MetricsRegisterer,
+		}
+		if options.JwksUrl != "" || options.JwksDiscoveryUrl != "" {
+			jwtOptions.KeySet, err = security.NewJwksKeySet(ctx, security.JwksKeySetOptions{
+				JwksUrl:         options.JwksUrl,
+				DiscoveryUrl:    options.JwksDiscoveryUrl,
+				RefreshInterval: options.JwksRefreshInterval,
+			})
+			if err != nil {
+				// breaking for the same reason
+				aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("Failed to fetch JWKS key set for JWT validation - bailing out: %s", err.Error())
+				return err
+			}
 		}
 		router.Use(security.JwtIdTokenValidatorMiddleware(jwtOptions))
 		router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("JwtIdTokenValidator"))
 	}
 	if options.HasBasicAuthAuthorization {
+		if err := security.ValidateBasicAuthUsers(options.BasicAuthUsers); err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("Invalid basic auth users - bailing out: %s", err.Error())
+			return err
+		}
 		basicAuthOptions := security.BasicAuthMiddlewareOptions{
 			BasicAuthUsername: options.BasicAuthUsername,
 			BasicAuthPassword: options.BasicAuthPassword,
 			BasicAuthClaims:   options.BasicAuthClaims,
+			Users:             options.BasicAuthUsers,
 		}
 		router.Use(security.BasicAuthValidatorMiddleware(basicAuthOptions))
 		router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("BasicAuthValidator"))
 	}
+	if options.HasApiKeyAuthorization {
+		if options.ApiKeyStore == nil {
+			err := errors.New("HasApiKeyAuthorization is set, but no ApiKeyStore")
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("Invalid api key setup - bailing out: %s", err.Error())
+			return err
+		}
+		apiKeyOptions := security.ApiKeyMiddlewareOptions{
+			Header: options.ApiKeyHeader,
+			Store:  options.ApiKeyStore,
+		}
+		router.Use(security.ApiKeyValidatorMiddleware(apiKeyOptions))
+		router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("ApiKeyValidator"))
+	}
+	if options.ClientCertAuthentication != nil {
+		router.Use(options.ClientCertAuthentication.Middleware)
+		router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("ClientCertAuthentication"))
+	}
 	if !options.DisableSecurityEnforcement {
 		allowThroughOptions := security.AuthRequiredMiddlewareOptions{
 			AllowUnauthorized: options.AllowUnauthorized,
@@ -138,16 +233,26 @@ func SetupStandardMiddlewareStack(ctx context.Context, router chi.Router, option
 		router.Use(security.AuthRequiredMiddleware(allowThroughOptions))
 		router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("AuthRequired"))
 	}
+	if options.AuthorizationPolicies != nil {
+		router.Use(options.AuthorizationPolicies.Middleware)
+		router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("AuthorizationPolicies"))
+	}
 
 	if options.RequestTimeoutSeconds > 0 {
-		if !options.SkipDuplicateSetup {
-			timeout.RequestTimeoutSeconds = options.RequestTimeoutSeconds
-		} else if timeout.RequestTimeoutSeconds != options.RequestTimeoutSeconds {
-			aulogging.Logger.Ctx(ctx).Warn().Print("Failed to set up request timeout for second web stack - timeouts cannot differ - timeout for both stacks remains set to %d seconds - continuing", timeout.RequestTimeoutSeconds)
-		}
-		router.Use(timeout.AddRequestTimeout)
+		router.Use(timeout.AddRequestTimeoutSeconds(options.RequestTimeoutSeconds))
 		router.Use(cancellogger.ConstructContextCancellationLoggerMiddleware("AddRequestTimeout"))
 	}
 
 	return nil
 }
+
+// VerifyRoutes checks the routes against the middleware stack options, once all controllers are wired up.
+//
+// Call it right before your server starts listening. It logs a warning for each route that no
+// AuthorizationPolicies apply to, and returns these routes.
+func VerifyRoutes(ctx context.Context, routes chi.Routes, options MiddlewareStackOptions) []string {
+	if options.AuthorizationPolicies == nil {
+		return []string{}
+	}
+	return options.AuthorizationPolicies.ReportUnmatchedRoutes(ctx, routes)
+}
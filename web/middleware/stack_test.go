@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/StephanHCB/go-backend-service-common/docs"
+	"github.com/StephanHCB/go-backend-service-common/web/middleware/security"
+	"github.com/StephanHCB/go-backend-service-common/web/middleware/security/securitytest"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStack_Twice(t *testing.T) {
+	docs.Description("the middleware stack can be set up twice in one process without special options")
+
+	registry := prometheus.NewRegistry()
+	first := tstRouter(t, registry)
+	second := tstRouter(t, registry)
+	other := tstRouter(t, prometheus.NewRegistry())
+
+	for _, router := range []chi.Router{first, second, other} {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/hello", nil))
+		require.Equal(t, http.StatusNoContent, response.Code)
+	}
+
+	expected := `
+# HELP http_server_requests_seconds_count Number of incoming HTTP requests processed, partitioned by status code, method and HTTP path (grouped by patterns).
+# TYPE http_server_requests_seconds_count counter
+http_server_requests_seconds_count{exception="None",method="GET",outcome="SUCCESS",status="204",uri="/hello"} 2
+`
+	require.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "http_server_requests_seconds_count"))
+}
+
+func TestStack_AuthorizationPolicies(t *testing.T) {
+	docs.Description("routes without an authorization policy are reported and forbidden, unknown routes are still 404")
+
+	policies, err := security.NewAuthorizationPolicies(security.AuthorizationPolicyMiddlewareOptions{
+		Policies: []security.Policy{{Route: "GET /hello"}},
+	})
+	require.Nil(t, err)
+	options := MiddlewareStackOptions{
+		DisableSecurityEnforcement: true,
+		AuthorizationPolicies:      policies,
+		MetricsRegisterer:          prometheus.NewRegistry(),
+	}
+	router := chi.NewRouter()
+	require.Nil(t, SetupStandardMiddlewareStack(context.Background(), router, options))
+	noop := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	router.Get("/hello", noop)
+	router.Post("/other", noop)
+
+	require.Equal(t, []string{"POST /other"}, VerifyRoutes(context.Background(), router, options))
+
+	for request, status := range map[string]int{
+		"GET /hello":   http.StatusNoContent,
+		"POST /other":  http.StatusForbidden,
+		"GET /missing": http.StatusNotFound,
+		"PUT /hello":   http.StatusMethodNotAllowed,
+	} {
+		methodAndPath := strings.SplitN(request, " ", 2)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(methodAndPath[0], methodAndPath[1], nil))
+		require.Equal(t, status, response.Code, request)
+	}
+}
+
+func TestStack_JwtAndForwardedClientCert(t *testing.T) {
+	docs.Description("a forwarded client certificate does not replace or reject the claims of a valid JWT")
+
+	keyPair := securitytest.NewKeyPair(t, "key1", "RS256")
+	clientCerts, err := security.NewClientCertAuthentication(security.ClientCertMiddlewareOptions{
+		Rules:               []security.ClientCertRule{{Subject: "CN=billing"}},
+		ForwardedCertHeader: "X-Forwarded-Client-Cert",
+		TrustedProxies:      []string{"127.0.0.1/32"},
+	})
+	require.Nil(t, err)
+	router := chi.NewRouter()
+	require.Nil(t, SetupStandardMiddlewareStack(context.Background(), router, MiddlewareStackOptions{
+		HasJwtIdTokenAuthorization: true,
+		JwtPublicKeyPEMs:           []string{keyPair.PublicKeyPEM()},
+		ClientCertAuthentication:   clientCerts,
+		MetricsRegisterer:          prometheus.NewRegistry(),
+	}))
+	router.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(security.Subject(r.Context())))
+	})
+
+	for authorization, status := range map[string]int{
+		keyPair.Bearer(securitytest.Token{Subject: "1234"}): http.StatusOK,
+		"": http.StatusUnauthorized,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		request.RemoteAddr = "127.0.0.1:1234"
+		request.Header.Set("X-Forwarded-Client-Cert", url.PathEscape(tstSelfSignedCertPEM(t, "unknown")))
+		if authorization != "" {
+			request.Header.Set(headers.Authorization, authorization)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		require.Equal(t, status, response.Code)
+		if status == http.StatusOK {
+			require.Equal(t, "1234", response.Body.String())
+		}
+	}
+}
+
+// --- helpers ---
+
+func tstRouter(t *testing.T, registerer prometheus.Registerer) chi.Router {
+	router := chi.NewRouter()
+	err := SetupStandardMiddlewareStack(context.Background(), router, MiddlewareStackOptions{
+		DisableSecurityEnforcement: true,
+		RequestTimeoutSeconds:      5,
+		MetricsRegisterer:          registerer,
+	})
+	require.Nil(t, err)
+
+	router.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return router
+}
+
+func tstSelfSignedCertPEM(t *testing.T, commonName string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
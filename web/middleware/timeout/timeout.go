@@ -8,14 +8,22 @@ import (
 
 var RequestTimeoutSeconds = 30
 
+// AddRequestTimeout uses the global RequestTimeoutSeconds.
 func AddRequestTimeout(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+	return AddRequestTimeoutSeconds(RequestTimeoutSeconds)(next)
+}
+
+// AddRequestTimeoutSeconds constructs a middleware with its own timeout, e.g. for a second middleware stack.
+func AddRequestTimeoutSeconds(seconds int) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
 
-		ctx, cancel := context.WithTimeout(ctx, time.Duration(RequestTimeoutSeconds)*time.Second)
-		defer cancel()
+			ctx, cancel := context.WithTimeout(ctx, time.Duration(seconds)*time.Second)
+			defer cancel()
 
-		next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
 	}
-	return http.HandlerFunc(fn)
 }